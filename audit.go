@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+)
+
+// Report of the check command.
+type audit struct {
+	Entries    int       `json:"entries"`
+	Missing    int       `json:"missing"`
+	Corrupt    int       `json:"corrupt"`
+	Mismatched int       `json:"mismatched"`
+	Problems   []problem `json:"problems"`
+	Orphans    removals  `json:"orphans"`
+	// Problem of the wallpapers file itself, see -store-checksum.
+	Store string `json:"store,omitempty"`
+}
+
+// Reconcile the store with imgDir without changing either: entries whose files are missing, corrupt
+// or don't match their checksums (as verify), entries whose stored size differs from the image, and
+// files no entry refers to (as prune-orphans).
+func checkStore(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	st := &store{path: wpFile}
+	entries, err := st.entries()
+	if err != nil {
+		return err
+	}
+	result := audit{Entries: len(entries), Problems: make([]problem, 0)}
+	if err = st.checkSeal(); err != nil {
+		result.Store = err.Error()
+	}
+	for _, e := range entries {
+		p := problem{Date: e.date.Format(localDateLayout), Filename: e.filename}
+		err := checkImage(e)
+		switch {
+		case err == nil:
+			if e.width == 0 && e.height == 0 {
+				continue
+			}
+			width, height, err := imageSize(filepath.Join(imgDir, e.filename))
+			if err != nil {
+				return err
+			}
+			if width == e.width && height == e.height {
+				continue
+			}
+			p.Status = "mismatched"
+			p.Error = fmt.Sprintf("%s: %s is %dx%d, stored as %dx%d", p.Date, e.filename, width, height, e.width, e.height)
+			result.Mismatched++
+		case errors.Is(err, errMissing):
+			p.Status, p.Error = "missing", err.Error()
+			result.Missing++
+		case errors.Is(err, errCorrupt):
+			p.Status, p.Error = "corrupt", err.Error()
+			result.Corrupt++
+		default:
+			return err
+		}
+		result.Problems = append(result.Problems, p)
+	}
+	found, err := orphans()
+	if err != nil {
+		return err
+	}
+	if result.Orphans, err = listRemovals(imgDir, found); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if err = printJSON(result); err != nil {
+			return err
+		}
+	} else {
+		if result.Store != "" {
+			fmt.Println(result.Store)
+		}
+		for _, p := range result.Problems {
+			fmt.Println(p.Error)
+		}
+		for _, o := range result.Orphans.Files {
+			fmt.Printf("%s: %s is not in the wallpapers file\n", o.Date, o.Name)
+		}
+		fmt.Printf("%d entries: %d missing, %d corrupt, %d mismatched, %d orphans\n", result.Entries,
+			result.Missing, result.Corrupt, result.Mismatched, len(result.Orphans.Files))
+	}
+	if len(result.Problems)+len(result.Orphans.Files) > 0 || result.Store != "" {
+		return fmt.Errorf("check: the wallpapers file and %s are inconsistent", imgDir)
+	}
+	return nil
+}
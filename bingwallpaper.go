@@ -3,18 +3,33 @@ Script downloads today's wallpaper from bing.gifposter.com, sets wallpaper and s
 wallpaper description. Information about downloaded wallpapers is saved into wpFile. If today's
 wallpaper has been downloaded already, script does nothing. If there are missed dates, script
 downloads wallpapers at that dates. wpFile's lines have the following format:
-YYYYMMDD <wallpaper-file-name> <description>.
+YYYYMMDD <wallpaper-file-name> <description>, optionally followed by tab-separated key=value
+fields (see store).
+Lines are sorted from the newest date to the oldest, files of older versions are upgraded by the
+first run (see store.migrate). If some missed date fails to download, it is
+retried by later runs, and lastDate is held in wpFile.lastdate until then.
 */
 package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,224 +44,1089 @@ const (
 )
 
 var (
-	imgDir    = fmt.Sprintf("%s/Images/bing-wallpapers", os.Getenv("HOME"))
-	wpFile    = fmt.Sprintf("%s/wallpapers", imgDir)
-	now       = time.Now()
-	today     = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
-	yesterday = today.AddDate(0, 0, -1)
-	lastDate  time.Time
+	// Computed by initPaths after parsing flags.
+	imgDir       string
+	wpFile       string
+	imgDirSource string
+	wpFileSource string
+	now          = time.Now()
+	today        = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	yesterday    = today.AddDate(0, 0, -1)
+	lastDate     time.Time
+	stats        runStats
+	// Resolved from -setter.
+	wallpaperSetter *setter
+	// Where downloaded images are written, set up by initPaths.
+	sink imageSink
+	// Context of all requests, cancelled when -deadline passes.
+	runCtx = context.Background()
+	// The run found the store empty, e.g. on a new install.
+	initRun bool
+	// Client of all requests, so that transport settings apply to pages and images alike.
+	httpClient = &http.Client{}
 )
 
+var (
+	langArg     = flag.String("lang", "", "language of messages: en, de; default from $LANG")
+	homeDir     = flag.String("home", "", "base directory instead of $HOME, wallpapers are saved into <home>/Images/bing-wallpapers")
+	dirArg      = flag.String("dir", "", "directory of wallpapers instead of <home>/Images/bing-wallpapers")
+	wpArg       = flag.String("wp-file", "", "file logging wallpapers instead of <dir>/wallpapers")
+	storeFormat = flag.String("store-format", "text", "format of the wp-file: "+strings.Join(storeFormats, ", ")+"; see the convert-store command")
+	// Unlike a dry run, wallpapers are still downloaded and logged, only the desktop is left alone.
+	noSet            = flag.Bool("no-set", false, "download and log new wallpapers without setting the wallpaper and showing the message")
+	setterName       = flag.String("setter", "auto", "program setting the wallpaper: "+strings.Join(setterNames(), ", ")+", or none like -no-set")
+	setScript        = flag.String("set-script", "", "script setting the wallpaper instead of -setter and -setter-cmd, gets BW_FILE, BW_TITLE, BW_DESCRIPTION, BW_DATE, BW_AUTHOR and BW_MODE in the environment")
+	setterCmd        = flag.String("setter-cmd", "", "command setting the wallpaper instead of -setter, {file} and {mode} are replaced with the image path and the fit mode")
+	fitMode          = flag.String("mode", fitModes[0], "how the wallpaper fits the screen: "+strings.Join(fitModes, ", "))
+	crop             = flag.Bool("crop", false, "set a centered crop of the wallpaper fitting the shape of -screen, e.g. for ultrawide monitors; the original is kept")
+	blurFill         = flag.Bool("blur-fill", false, "set the wallpaper centered over a blurred copy of itself filling the shape of -screen; the original is kept")
+	screenArg        = flag.String("screen", "auto", "screen shape for -crop and -blur-fill: auto (detected), WxH in pixels or W:H")
+	captionOverlay   = flag.Bool("overlay-caption", false, "set a copy of the wallpaper with its title drawn into a corner; the original is kept")
+	overlayDate      = flag.String("overlay-date", "", "Go layout of the date drawn below the title by -overlay-caption, e.g. \"January 2, 2006\"; empty for none")
+	overlayPosition  = flag.String("overlay-position", overlayPositions[0], "corner of -overlay-caption: "+strings.Join(overlayPositions, ", "))
+	overlaySize      = flag.Float64("overlay-size", 2.5, "font size of -overlay-caption in percent of the image height")
+	overlayOpacity   = flag.Float64("overlay-opacity", 0.7, "opacity of -overlay-caption from 0 to 1")
+	captionFile      = flag.String("caption-file", "", "write the caption of the wallpaper into this file whenever it is set")
+	captionFormat    = flag.String("caption-format", `{title}\n{description}`, "caption written by -caption-file, {title}, {description}, {author}, {date}, {file} and \\n are replaced")
+	notifyImage      = flag.Bool("notify-image", false, "show the wallpaper in the message, with notify-send if installed")
+	notifyDate       = flag.Bool("notify-date", false, "show the date of the wallpaper in the message")
+	notifyDateFormat = flag.String("notify-date-format", "Monday, January 2, 2006", "Go layout of the date in the message")
+	headlessArg      = flag.String("headless", "auto", "whether there is no display session, so the wallpaper isn't set: auto (no $DISPLAY and $WAYLAND_DISPLAY), yes, no")
+	// The gifposter listing serves a single market, so it only takes effect with sources offering others.
+	formatArg          = flag.String("format", "", "preferred image formats if the page offers several, comma-separated, e.g. webp,jpg; default the main image")
+	marketArg          = flag.String("market", "", "Bing market code, e.g. en-US, default the market of the source; see the markets command")
+	futureDays         = flag.Int("future-days", 0, "download wallpapers dated up to this many days after today; the listing may have later dates before they are available")
+	nameTemplate       = flag.String("name-template", "{orig}", "name of saved wallpapers without the extension: {date}, {title} and {orig} (the original name) are replaced")
+	copyTo             = flag.String("copy-to", "", "also copy new wallpapers into this directory, e.g. of a screensaver")
+	linkCopy           = flag.Bool("link", false, "hard link the files of -copy-to instead of copying them")
+	dateLink           = flag.Bool("date-link", false, "also create YYYYMMDD.<ext> symlinks to downloaded wallpapers")
+	webpToJPEG         = flag.Bool("webp-to-jpeg", false, "convert WebP wallpapers to JPEG for setters which can't display WebP")
+	keepOrig           = flag.Bool("keep-original", false, "keep the original WebP file next to the converted JPEG")
+	maxPerRun          = flag.Int("max-per-run", 0, "download at most this many missed dates per run, oldest first, the rest by the next runs; 0 for no limit")
+	noBackfill         = flag.Bool("no-backfill", false, "only download the newest wallpaper, dates missed in between are never downloaded")
+	storeChecksum      = flag.Bool("store-checksum", false, "end the wallpapers file with a line counting and checksumming the records, so a file cut short is noticed")
+	noSetOnInit        = flag.Bool("no-set-on-init", false, "don't set the wallpaper on the first run, which finds the wallpapers file empty; later runs set new wallpapers")
+	fallbackLatest     = flag.Bool("fallback-latest", false, "if the newest wallpaper can't be downloaded yet, set the newest one this run downloaded instead")
+	fallbackColor      = flag.String("fallback-color", "", "if nothing is stored after a run, e.g. on the first boot without network, set an image of this color, #RRGGBB, or of a gradient of two separated by a comma")
+	setWindow          = flag.String("set-window", "", "set the wallpaper only between these local times, e.g. 00:00-06:00; wallpapers downloaded outside are set by the first run inside")
+	setFirst           = flag.Bool("set-first", false, "set the newest wallpaper before downloading missed dates")
+	minWidth           = flag.Int("min-width", 0, "don't set wallpapers narrower than this, e.g. thumbnails parsed by mistake")
+	minHeight          = flag.Int("min-height", 0, "don't set wallpapers lower than this")
+	mirrorPatternArg   = flag.String("mirror-pattern", "", "regular expression of image urls which are also on the mirror of -mirror-url")
+	mirrorURL          = flag.String("mirror-url", "", "url of the image on the mirror tried if the image fails, $1, $2... are replaced by the groups of -mirror-pattern")
+	maxImageSize       = flag.Int64("max-image-size", 50<<20, "fail dates whose image has more bytes than this, 0 for no limit")
+	discardSmall       = flag.Bool("discard-small", false, "don't save wallpapers smaller than -min-width and -min-height either; their dates are retried")
+	trashDirArg        = flag.String("trash-dir", "", "directory for removed images, default <img-dir>/.trash")
+	hardDelete         = flag.Bool("hard-delete", false, "remove images instead of moving them into the trash directory")
+	imageSelectorsArg  = flag.String("image-selectors", "a[href*='_UHD'][@href]", "elements linking to a larger image than the one shown, tried in order before the built-in ones: CSS selectors with [@attr], separated by ;")
+	locationPatternArg = flag.String("location-pattern", `\b(?:in|at|near|from) ((?:the )?\p{Lu}[\p{L}'’.-]*(?: \p{Lu}[\p{L}'’.-]*)*(?:, \p{Lu}[\p{L}'’.-]*(?: \p{Lu}[\p{L}'’.-]*)*)+)`, "regular expression finding the location in the title or description, its first group or the match is stored; empty to store none")
+	nextSelectorArg    = flag.String("next-selector", "", "element of the listing holding the next page, e.g. a[rel='next'][@href]: CSS selector, followed by [@attr] for an attribute instead of the text; empty to read the first page only")
+	pageParam          = flag.String("page-param", "", "query parameter taking the value of -next-selector as a page token instead of it being a link")
+	soft404            = flag.String("soft-404", "404,not found", "comma-separated phrases in the title or heading of a page served with status 200 which mean it doesn't exist (yet)")
+	explainRun         = flag.Bool("explain", false, "print each step of the run: fetched urls, matched selectors, parsed values, commands and store writes")
+	saveHTMLDir        = flag.String("save-html", "", "save fetched listing, transitional and detail pages into this directory for debugging")
+	rps                = flag.Float64("rps", 2, "at most this many requests per second to the site and the image host together, 0 for no limit")
+	maxRedirects       = flag.Int("max-redirects", 10, "follow at most this many redirects per request, e.g. of mirrors; loops fail at once")
+	jitter             = flag.Duration("jitter", 0, "wait a random duration up to this before the run, e.g. 15m, so cron jobs of many machines don't hit the site at once")
+	noJitter           = flag.Bool("no-jitter", false, "ignore -jitter, for manual runs")
+	deadline           = flag.Duration("deadline", 0, "stop downloading after this duration, e.g. 10m, keeping what is done; 0 for no limit")
+	quiet              = flag.Bool("quiet", false, "don't print the summary of the run")
+	metricsFile        = flag.String("metrics-file", "", "write Prometheus metrics for the node_exporter textfile collector to this file")
+	staleAfter         = flag.String("stale-after", "", "warn and exit with status 4 if the newest stored wallpaper is older than this, e.g. 3d or 72h, as the source may be broken")
+)
+
+// Expand a leading ~ to the home directory and $VAR references, which only a shell would do. Paths
+// from cron lines, quoted flags or environment variables reach the script verbatim.
+func expandPath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = home + path[1:]
+		}
+	}
+	return os.ExpandEnv(path)
+}
+
+// Compute paths: the image directory is -dir or <base>/Images/bing-wallpapers, where the base
+// directory is -home or $HOME; the wallpapers file is -wp-file or <dir>/wallpapers. Path flags are
+// expanded with expandPath.
+func initPaths() error {
+	for _, path := range []*string{homeDir, dirArg, wpArg, trashDirArg, saveHTMLDir, metricsFile, captionFile, copyTo} {
+		*path = expandPath(*path)
+	}
+	if *dirArg != "" {
+		imgDir, imgDirSource = *dirArg, flagSource("dir")
+	} else {
+		base, source := *homeDir, flagSource("home")
+		if base == "" {
+			base, source = os.Getenv("HOME"), "$HOME"
+		}
+		if base == "" {
+			return fmt.Errorf("Could not find base directory: neither -dir nor -home nor $HOME is set")
+		}
+		imgDir, imgDirSource = fmt.Sprintf("%s/Images/bing-wallpapers", base), source
+	}
+	wpFile, wpFileSource = fmt.Sprintf("%s/wallpapers", imgDir), "img-dir"
+	if *wpArg != "" {
+		wpFile, wpFileSource = *wpArg, flagSource("wp-file")
+	}
+	sink = &localSink{dir: imgDir}
+	return nil
+}
+
+// Error stopping the program, see check.
+type fatalError struct {
+	err error
+}
+
+// Stop on the error: configuration errors and errors every further run would fail with as well.
+// The error unwinds to main, which logs it without a stack trace and exits with exitFailed after
+// the metrics are written.
 func check(err error) {
 	if err != nil {
-		log.Panic(err)
+		panic(fatalError{err})
 	}
 }
 
 func getResponse(url string) (*http.Response, error) {
-	response, err := http.Get(url)
+	request, err := http.NewRequestWithContext(runCtx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("Could not get response from url %s: %s", url, err)
+		return nil, fmt.Errorf("Could not create request for url %s: %s", url, err)
+	}
+	explain("GET %s", url)
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, withKind(errFetch, fmt.Errorf("Could not get response from url %s: %w", url, err))
+	}
+	explain("%s: %s, %s, %d bytes", url, response.Status, response.Header.Get("Content-Type"), response.ContentLength)
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, fmt.Errorf("%s: %w", url, errNotAvailable)
 	}
 	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("%s: status code error: %d %s", url, response.StatusCode, response.Status)
+		response.Body.Close()
+		return nil, withKind(errFetch, fmt.Errorf("%s: status code error: %d %s", url, response.StatusCode, response.Status))
 	}
 	return response, nil
 }
 
-// Download wallpaper from the url.
-func downloadWallpaper(url string) (time.Time, string, string, string, error) {
-	var date time.Time
-	var filename, title, description string
+// Absolute url of the link on the page, whether the link is absolute, root-relative or relative.
+func resolveURL(page, href string) (string, error) {
+	base, err := neturl.Parse(page)
+	if err != nil {
+		return "", withKind(errParse, fmt.Errorf("Malformed url %q: %s", page, err))
+	}
+	ref, err := neturl.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", withKind(errParse, fmt.Errorf("Malformed link %q on %s: %s", href, page, err))
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// Pages of the -save-html directory saved by earlier runs, by url, as they were before this run
+// replaced them. They are the fallback of pages which fail to parse, see downloadWallpaper.
+var previousPages = make(map[string][]byte)
+
+// Whether fetchPage takes pages from previousPages instead of the site.
+var fromCache bool
+
+// Fetch and parse the page. With -save-html, the page is also saved as <kind>-<url path>.html for
+// debugging selectors.
+func fetchPage(url, kind string) (*goquery.Document, error) {
+	body, cached := previousPages[url]
+	if fromCache && cached {
+		explain("using the page saved by an earlier run for %s", url)
+	} else {
+		response, err := getResponse(url)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+		body, err = io.ReadAll(response.Body)
+		stats.pageBytes += int64(len(body))
+		if err != nil {
+			return nil, withKind(errFetch, fmt.Errorf("Could not read %s: %w", url, err))
+		}
+		if *saveHTMLDir != "" {
+			saveHTML(url, kind, body)
+		}
+	}
+	root, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, withKind(errParse, fmt.Errorf("Could not parse %s: %s", url, err))
+	}
+	if kind != "listing" {
+		if marker := softNotFound(root); marker != "" {
+			return nil, fmt.Errorf("%s: page says %q: %w", url, marker, errNotAvailable)
+		}
+	}
+	return root, nil
+}
+
+// Save the page into -save-html directory. Failures are only logged, because it is a debugging aid.
+func saveHTML(url, kind string, body []byte) {
+	name := strings.TrimPrefix(url, baseURL)
+	name = strings.Trim(strings.TrimSuffix(name, ".html"), "/")
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '?' || r == '&' || r == '=' || r == ':' {
+			return '-'
+		}
+		return r
+	}, name)
+	path := filepath.Join(*saveHTMLDir, kind+"-"+name+".html")
+	if _, seen := previousPages[url]; !seen {
+		if previous, err := os.ReadFile(path); err == nil {
+			previousPages[url] = previous
+		}
+	}
+	err := os.MkdirAll(*saveHTMLDir, 0755)
+	if err == nil {
+		err = os.WriteFile(path, body, 0644)
+	}
+	if err != nil {
+		log.Print(msg("page-not-saved", url, err))
+	}
+}
+
+// Replace the file with data atomically: write a temporary file in the same directory and rename
+// it. The temporary name is hidden and has no extension, so watchers of the directory ignore it.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Reader of a response body remembering its error, so that a failed download can be told from a
+// failed write.
+type bodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// Wallpaper parsed from its pages.
+type wallpaper struct {
+	date        time.Time
+	title       string
+	description string
+	// Copyright holder of the photo.
+	author string
+	// Url of the page with photo.
+	page string
+	// Url of the image.
+	src string
+	// Url of the image on the mirror if the mirror served it.
+	mirror string
+	// Id of the image at Bing, e.g. OHR.Hallstatt_EN-US1234567890, empty if the url has none.
+	bingID string
+	// Place of the photo found by -location-pattern, empty if unknown.
+	location string
+	// Name of the downloaded file in imgDir.
+	filename string
+	// Dimensions of the image, zero if unknown.
+	width  int
+	height int
+	// SHA-256 checksum of the file, hex-encoded.
+	sha256 string
+	// Format of the downloaded image, its extension without the dot.
+	format string
+	// Bytes downloaded for the wallpaper, pages and image.
+	bytes int64
+}
+
+// Navigate from the url to the page with photo and parse the wallpaper.
+func resolveWallpaper(url string) (wallpaper, error) {
+	var wp wallpaper
 
 	// Transitional page.
-	response, err := getResponse(url)
+	root, err := fetchPage(url, "transitional")
 	// Sometimes transitional page returns error 500.
 	if err != nil {
-		return date, filename, title, description, err
+		return wp, err
 	}
-	defer response.Body.Close()
-	root, err := goquery.NewDocumentFromReader(response.Body)
-	check(err)
 
 	// Parse the page and fetch href for the next page.
-	href, ok := root.Find("a.fl").First().Attr("href")
-	if !ok {
-		log.Panicf("Could not find href on the transitional page at date %s", date.Format(localDateLayout))
+	href, err := findValue(root, url, "link to the detail page", transitionalLinkSelectors)
+	if err != nil {
+		return wp, err
+	}
+	if href, err = resolveURL(url, href); err != nil {
+		return wp, err
 	}
-	href = baseURL + href
 
-	// Page with photo.
-	response, err = getResponse(href)
-	check(err)
-	defer response.Body.Close()
-	root, err = goquery.NewDocumentFromReader(response.Body)
-	check(err)
+	return resolveDetail(href)
+}
+
+// Fetch and parse the page with photo at href.
+func resolveDetail(href string) (wallpaper, error) {
+	root, err := fetchPage(href, "detail")
+	if err != nil {
+		return wallpaper{page: href}, err
+	}
+	return parseDetail(root, href)
+}
+
+// Parse the wallpaper from its page with photo at href.
+func parseDetail(root *goquery.Document, href string) (wallpaper, error) {
+	wp := wallpaper{page: href}
+	dateStr, err := findValue(root, href, "date", dateSelectors)
+	if err != nil {
+		return wp, err
+	}
+	wp.date, err = time.Parse(remoteDateLayout, dateStr)
+	if err != nil {
+		return wp, withKind(errParse, fmt.Errorf("Could not parse date on %s: %s", href, err))
+	}
 
 	detail := root.Find("div.detail")
-	dateStr := detail.Find("time[itemprop='date']").Text()
-	date, err = time.Parse(remoteDateLayout, dateStr)
-	check(err)
+	title, author, _ := strings.Cut(detail.Find("div.title").Text(), "©")
+	wp.title = strings.TrimSpace(title)
+	wp.author = strings.TrimSpace(author)
 
-	title = detail.Find("div.title").Text()
-	title = strings.TrimSpace(strings.Split(title, "©")[0])
+	wp.description = detail.Find("div.description").Text()
 
-	description = detail.Find("div.description").Text()
+	wp.src, err = findValue(root, href, "image", slices.Concat(largeImageSelectors, imageSelectors))
+	if err != nil {
+		return wp, err
+	}
+	if wp.src, err = resolveURL(href, wp.src); err != nil {
+		return wp, err
+	}
+	if *formatArg != "" {
+		offers := imageOffers(root, href, wp.src)
+		for _, format := range strings.Split(*formatArg, ",") {
+			if src, ok := offers[strings.ToLower(strings.TrimSpace(format))]; ok {
+				wp.src = src
+				break
+			}
+		}
+	}
+	wp.location = findLocation(wp.title, wp.description)
+	explain("parsed %s: date %s, title %q, author %q, location %q, image %s", href, wp.date.Format(localDateLayout),
+		wp.title, wp.author, wp.location, wp.src)
+	return wp, nil
+}
+
+// Download wallpaper from the url of its transitional page, as linked by the listing.
+func downloadWallpaper(url string) (wallpaper, error) {
+	return download(url, resolveWallpaper)
+}
+
+// Download wallpaper from the url of its page with photo, e.g. the one stored in its entry, without
+// the listing and the transitional page.
+func downloadDetail(page string) (wallpaper, error) {
+	return download(page, resolveDetail)
+}
 
-	img := root.Find("#bing_wallpaper")
-	src, ok := img.Attr("src")
-	if !ok {
-		log.Panicf("Could not find img src on url %s", url)
+// Download wallpaper from the url, whose pages resolve parses.
+func download(url string, resolve func(url string) (wallpaper, error)) (wallpaper, error) {
+	// Pages are fetched one after another, so the bytes read meanwhile are this wallpaper's.
+	pageBytes := stats.pageBytes
+	// A page cut short by a flaky connection fails to parse once, changed markup every time. With
+	// -save-html the pages saved by an earlier run are the last resort.
+	wp, err := resolve(url)
+	for attempt := 1; errors.Is(err, errParse) && attempt < parseAttempts && runCtx.Err() == nil; attempt++ {
+		log.Print(msg("parse-retry", err))
+		time.Sleep(parseRetryDelay)
+		wp, err = resolve(url)
+	}
+	if errors.Is(err, errParse) && *saveHTMLDir != "" {
+		fromCache = true
+		if cachedWp, cachedErr := resolve(url); cachedErr == nil {
+			log.Print(msg("parse-cached", url))
+			wp, err = cachedWp, nil
+		}
+		fromCache = false
+	}
+	if errors.Is(err, errParse) {
+		return wp, fmt.Errorf("%w; it failed %d times, the markup of the site may have changed", err, parseAttempts)
+	}
+	if err != nil {
+		return wp, err
 	}
-	lastSlashIndex := strings.LastIndex(src, "/")
-	filename = src[lastSlashIndex+1:]
-	filepath := fmt.Sprintf("%s/%s", imgDir, filename)
 
-	// Download image.
-	output, err := os.Create(filepath)
+	// Download image, from the mirror if the image host fails.
+	src := wp.src
+	response, err := getResponse(src)
+	if err != nil && runCtx.Err() == nil {
+		if alt, ok := mirrorOf(src); ok {
+			log.Print(msg("mirror", err, alt))
+			if response, err = getResponse(alt); err == nil {
+				src, wp.mirror = alt, alt
+			}
+		}
+	}
 	if err != nil {
-		log.Panicf("Could not create file %s, err: %s", filepath, err)
+		return wp, err
 	}
-	defer output.Close()
-	response, err = getResponse(src)
-	check(err)
 	defer response.Body.Close()
-	_, err = io.Copy(output, response.Body)
+
+	if *maxImageSize > 0 && response.ContentLength > *maxImageSize {
+		return wp, fmt.Errorf("%s has %d bytes: %w", src, response.ContentLength, errTooLarge)
+	}
+
+	// The extension is taken from the content, the url may have a wrong one or none.
+	received := &bodyReader{r: response.Body}
+	body := bufio.NewReader(received)
+	head, _ := body.Peek(512)
+	lastSlashIndex := strings.LastIndex(wp.src, "/")
+	filename := wp.src[lastSlashIndex+1:]
+	ext := detectExtension(head, response.Header.Get("Content-Type"))
+	filename, err = imageName(wp, withExtension(filename, ext))
+	if err != nil {
+		return wp, err
+	}
+	wp.format = strings.TrimPrefix(ext, ".")
+	wp.bingID = bingID(wp.src)
+	explain("saving %s as %s", src, filename)
+	hash := sha256.New()
+	var n byteCounter
+	meta := imageMeta{date: wp.date, contentType: response.Header.Get("Content-Type"), source: src}
+	// Images failing the limits are dropped before they replace the stored one, so a redownload
+	// keeps the good image. Dimensions are read before conversion, which keeps them.
+	var rejected error
+	meta.check = func(path string) error {
+		if *maxImageSize > 0 && int64(n) > *maxImageSize {
+			rejected = fmt.Errorf("%s has more than %d bytes: %w", src, *maxImageSize, errTooLarge)
+			return rejected
+		}
+		width, height, err := imageSize(path)
+		if err != nil {
+			log.Println(strings.Replace(err.Error(), path, filename, 1))
+			return nil
+		}
+		wp.width, wp.height = width, height
+		if !bigEnough(width, height) && *discardSmall {
+			rejected = fmt.Errorf("%s is %dx%d: %w", filename, width, height, errTooSmall)
+			return rejected
+		}
+		return nil
+	}
+	var image io.Reader = body
+	if *maxImageSize > 0 {
+		// Content-Length may be missing or lie, one byte over the limit is enough to tell.
+		image = io.LimitReader(body, *maxImageSize+1)
+	}
+	err = sink.put(filename, io.TeeReader(image, io.MultiWriter(hash, &n)), meta)
+	stats.bytes += int64(n)
+	wp.bytes = int64(n) + stats.pageBytes - pageBytes
+	if err != nil && runCtx.Err() != nil {
+		return wp, fmt.Errorf("%s: %w", src, runCtx.Err())
+	}
+	if rejected != nil {
+		return wp, rejected
+	}
+	// A connection dropped during the download fails the date like a failed request, the sink keeps
+	// the previous image. Failures of the sink itself, e.g. a full disk, stop the run.
+	if err != nil && received.err != nil {
+		return wp, withKind(errFetch, fmt.Errorf("Could not download %s: %w", src, received.err))
+	}
 	if err != nil {
-		log.Panicf("Could not write image to file, err: %s", err)
+		return wp, withKind(errStore, err)
 	}
+	wp.sha256 = hex.EncodeToString(hash.Sum(nil))
 
-	return date, filename, title, description, nil
+	if *webpToJPEG {
+		converted, err := convertWebP(filename)
+		if err != nil {
+			log.Println(err)
+		}
+		if converted != filename {
+			filename = converted
+			wp.format = "jpg"
+			if wp.sha256, err = fileChecksum(fmt.Sprintf("%s/%s", imgDir, filename)); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	if *dateLink {
+		if err = linkByDate(wp.date.Format(localDateLayout), filename); err != nil {
+			log.Println(err)
+		}
+	}
+	if *copyTo != "" {
+		if err = copyToDir(filename); err != nil {
+			log.Println(err)
+		}
+	}
+
+	wp.filename = filename
+	return wp, nil
 }
 
-// Set wallpaper and show message with description.
-func setWallpaper(filename, title, description string) {
-	filepath := fmt.Sprintf("%s/%s", imgDir, filename)
+// Set the wallpaper and show its description. A failing setter is retried a few times, e.g. when
+// the desktop isn't ready yet right after login. The description is a courtesy, failing to show it
+// is only logged.
+func setWallpaper(wp wallpaper) error {
+	filename, title, description := wp.filename, wp.title, wp.description
+	// In headless runs (cron without a session) GUI programs only fail.
+	headless := isHeadless()
+	if headless && !wallpaperSetter.noDisplay {
+		return errors.New(msg("no-display-set", filename))
+	}
 
-	setWallpaperCmd := exec.Command("fbsetbg", "-f", filepath)
-	err := setWallpaperCmd.Start()
-	check(err)
+	filepath, err := applyWallpaper(wp)
+	if err != nil {
+		stats.failures++
+		stats.setFailures++
+		return err
+	}
+	stats.set = true
+	if *captionFile != "" {
+		if err = writeCaption(wp, filepath); err != nil {
+			log.Print(err)
+		}
+	}
 
-	msgCmd := exec.Command("zenity", "--info", "--width=600", "--no-markup", "--title", title, "--text", title+"\n\n"+description)
-	err = msgCmd.Start()
-	check(err)
+	if headless {
+		log.Print(msg("no-display-message"))
+		return nil
+	}
+	text := title + "\n\n" + description
+	if *notifyDate && !wp.date.IsZero() {
+		text = wp.date.Format(*notifyDateFormat) + "\n" + text
+	}
+	msgCmd := exec.Command("zenity", "--info", "--width=600", "--no-markup", "--title", title, "--text", text)
+	// notify-send shows the image in the notification, zenity only as the window icon.
+	if *notifyImage {
+		if _, err := exec.LookPath("notify-send"); err == nil {
+			msgCmd = exec.Command("notify-send", "--icon", filepath, title, text)
+		} else {
+			msgCmd.Args = append(msgCmd.Args, "--window-icon", filepath)
+		}
+	}
+	explain("running %q", msgCmd.Args)
+	if err = msgCmd.Start(); err != nil {
+		log.Print(msg("notify-failed", err))
+	}
+	return nil
 }
 
+// Set the wallpaper, cropped or filled to the screen with -crop and -blur-fill, without the
+// message, retrying a failing setter. Returns the path of the file which was set.
+func applyWallpaper(wp wallpaper) (string, error) {
+	filename := wp.filename
+	if *crop || *blurFill {
+		screen, err := parseScreen(*screenArg)
+		if err == nil && *crop {
+			filename, err = cropToScreen(filename, screen)
+		} else if err == nil {
+			filename, err = fillToScreen(filename, screen)
+		}
+		// The original image is better than none.
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	if *captionOverlay {
+		var err error
+		if filename, err = overlayCaption(filename, wp); err != nil {
+			log.Println(err)
+		}
+	}
+	filepath := fmt.Sprintf("%s/%s", imgDir, filename)
+	// Metadata for -set-script.
+	wallpaperSetter.environ = []string{
+		"BW_FILE=" + filepath,
+		"BW_TITLE=" + wp.title,
+		"BW_DESCRIPTION=" + wp.description,
+		"BW_DATE=" + wp.date.Format(localDateLayout),
+		"BW_AUTHOR=" + wp.author,
+		"BW_MODE=" + *fitMode,
+	}
+	err := wallpaperSetter.set(filepath, *fitMode)
+	for attempt := 1; err != nil && attempt < setAttempts; attempt++ {
+		log.Print(msg("set-retry", err))
+		time.Sleep(setRetryDelay)
+		err = wallpaperSetter.set(filepath, *fitMode)
+	}
+	return filepath, err
+}
+
+// Write the caption of the wallpaper which has been set into -caption-file for status bars.
+func writeCaption(wp wallpaper, path string) error {
+	caption := strings.NewReplacer(
+		`\n`, "\n",
+		"{title}", wp.title,
+		"{description}", wp.description,
+		"{author}", wp.author,
+		"{date}", wp.date.Format(localDateLayout),
+		"{file}", path,
+	).Replace(*captionFormat)
+	if err := writeFileAtomic(*captionFile, []byte(caption+"\n"), 0644); err != nil {
+		return fmt.Errorf("Could not write caption to %s: %s", *captionFile, err)
+	}
+	return nil
+}
+
+// Attempts to set the wallpaper in one run and the delay between them.
+const (
+	setAttempts   = 3
+	setRetryDelay = 5 * time.Second
+)
+
+// Attempts to fetch and parse the pages of a wallpaper and the delay between them.
+const parseAttempts = 3
+
+var parseRetryDelay = 2 * time.Second
+
 // Save record about wallpaper into file.
-func logWallpaper(date time.Time, filename, title, description string) {
-	// Escape some characters for sed.
-	description = title + ".  " + description
-	fixedDescription := description
-	fixedDescription = strings.Replace(fixedDescription, "&", `\x26`, -1)
-	fixedDescription = strings.Replace(fixedDescription, "'", `\x27`, -1)
-	fixedDescription = strings.Replace(fixedDescription, ";", `\x3b`, -1)
-	line := fmt.Sprintf("%s %s %s\\n", date.Format(localDateLayout), filename, fixedDescription)
-	sedCmd := exec.Command("sed", "-i", fmt.Sprintf("1s;^;%s;", line), wpFile)
-	err := sedCmd.Run()
+func logWallpaper(wp wallpaper) error {
+	st := &store{path: wpFile}
+	return st.add(entry{
+		date:        wp.date,
+		filename:    wp.filename,
+		description: wp.title + ".  " + wp.description,
+		page:        wp.page,
+		image:       wp.src,
+		mirror:      wp.mirror,
+		bingID:      wp.bingID,
+		location:    wp.location,
+		width:       wp.width,
+		height:      wp.height,
+		sha256:      wp.sha256,
+		format:      wp.format,
+		author:      wp.author,
+		bytes:       wp.bytes,
+	})
+}
+
+func main() {
+	// Deferred first, so that it runs after the metrics are written. Other panics are bugs and keep
+	// their stack trace.
+	defer func() {
+		if r := recover(); r != nil {
+			fatal, ok := r.(fatalError)
+			if !ok {
+				panic(r)
+			}
+			log.Print(fatal.err)
+			os.Exit(exitFailed)
+		}
+	}()
+	flag.Usage = usage
+	flag.Parse()
+	check(applyEnv())
+	check(initPaths())
+	// Containers without a display configure it with $BINGWALLPAPER_SETTER=none.
+	if *setterName == "none" {
+		*noSet = true
+	}
+	check(checkMarket(*marketArg))
+	check(checkStoreFormat(*storeFormat))
+	check(initMirror())
+	check(initPagination())
+	check(initImageSelectors())
+	check(initLocation())
+	check(initFallbackColor())
+	var err error
+	setWindowRange, err = parseWindow(*setWindow)
 	check(err)
+	limitRequests(*rps)
+	check(limitRedirects(*maxRedirects))
 
-	// Check that first line matches original description.
-	f, err := os.Open(wpFile)
+	if !*noSet {
+		var err error
+		if *setScript != "" {
+			wallpaperSetter, err = scriptSetter(*setScript)
+		} else if *setterCmd != "" {
+			wallpaperSetter, err = customSetter(*setterCmd)
+		} else {
+			wallpaperSetter, err = findSetter(*setterName)
+		}
+		check(err)
+		check(checkFitMode(*fitMode))
+		if *crop && *blurFill {
+			check(fmt.Errorf("-crop and -blur-fill exclude each other"))
+		}
+		check(checkHeadless(*headlessArg))
+		if *captionOverlay {
+			check(checkOverlay())
+		}
+	}
+
+	// Only the regular run, which is the scheduled one, waits. The deadline starts after the wait.
+	if *jitter > 0 && !*noJitter && flag.NArg() == 0 {
+		delay := time.Duration(rand.Int64N(int64(*jitter)))
+		log.Print(msg("jitter", delay.Round(time.Second)))
+		time.Sleep(delay)
+	}
+
+	staleLimit, err := parseAge(*staleAfter)
 	check(err)
-	defer f.Close()
-	reader := bufio.NewReader(f)
-	firstLine, err := reader.ReadString('\n')
+
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(context.Background(), *deadline)
+		defer cancel()
+	}
+
+	// Errors of commands are the user's, e.g. an unknown date, and need no stack trace.
+	if flag.NArg() > 0 {
+		cmd, err := findCommand(flag.Arg(0))
+		if err == nil {
+			err = cmd.run(flag.Args()[1:])
+		}
+		if err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Deferred before the metrics, so that they are written before the exit. Fatal errors exit with
+	// exitFailed, see check.
+	finished := false
+	defer func() {
+		if code := stats.exitCode(); finished && code != exitOK {
+			os.Exit(code)
+		}
+	}()
+	// Metrics are written even if the run stops on a fatal error, so that failures are visible too.
+	if *metricsFile != "" {
+		defer func() {
+			if err := writeMetrics(*metricsFile, stats); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+
+	run()
+	finished = true
+	// A run stopped by -deadline or by an unreachable site is clean but incomplete.
+	stats.incomplete = stats.incomplete || runCtx.Err() != nil
+	stats.success = !stats.incomplete
+	// The age is checked whatever the run did: a scraper broken by a changed page fails quietly
+	// every day, and only the stored dates tell.
+	newest, err := (&store{path: wpFile}).newest()
 	check(err)
-	substrings := strings.SplitN(firstLine, " ", 3)
-	savedDescription := substrings[len(substrings)-1]
-	if strings.TrimSpace(savedDescription) != strings.TrimSpace(description) {
-		log.Printf("%s: Original description and saved description are mismatched.", date.Format(localDateLayout))
+	stats.newest = newest
+	if staleLimit > 0 && !newest.IsZero() && today.Sub(newest) > staleLimit {
+		stats.stale = true
+		log.Print(msg("stale", newest.Format("2006-01-02"), *staleAfter))
+	}
+	// Runs with nothing to do stay silent, otherwise cron would mail every hour.
+	if !*quiet && stats.dates > 0 {
+		set := msg("no")
+		if stats.set {
+			set = msg("yes")
+		}
+		summary := msg("summary", stats.dates, stats.downloaded, float64(stats.bytes+stats.pageBytes)/(1<<20), stats.failures, set,
+			time.Since(now).Round(time.Millisecond))
+		if *copyTo != "" {
+			summary += msg("summary-copied", stats.copied, *copyTo)
+		}
+		log.Print(summary)
 	}
 }
 
-func main() {
-	// Create directory if not exists.
-	_, err := os.Stat(imgDir)
-	if os.IsNotExist(err) {
-		err = os.Mkdir(imgDir, 0755)
-		check(err)
+// Parse an age like 72h or 3d; time.ParseDuration has no days. An empty age is 0.
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("Invalid age %q, expected e.g. 3d or 72h", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("Invalid age %q, expected e.g. 3d or 72h", s)
 	}
+	return d, nil
+}
 
-	// Fetch the last date and, if the last date is today, exit.
-	_, err = os.Stat(wpFile)
+// Create directory if not exists, with missing parents. A file in its place or a directory which
+// can't be written fails here rather than at the first download.
+func ensureImgDir() error {
+	info, err := os.Stat(imgDir)
 	if os.IsNotExist(err) {
-		f, err := os.Create(wpFile)
-		check(err)
-		_, err = f.WriteString("\n")
-		check(err)
-		f.Close()
-	} else {
-		f, err := os.Open(wpFile)
-		check(err)
-		lastDateBytes := make([]byte, 8) // YYYYMMDD
-		_, err = f.Read(lastDateBytes)
-		check(err)
-		f.Close()
+		return os.MkdirAll(imgDir, 0755)
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory, remove it or choose another one with -dir", imgDir)
+	}
+	f, err := os.CreateTemp(imgDir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("Directory %s is not writable, fix its permissions or choose another one with -dir: %s", imgDir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func run() {
+	check(ensureImgDir())
 
-		lastDate, err = time.Parse(localDateLayout, string(lastDateBytes))
+	st := &store{path: wpFile}
+	check(st.migrate())
+	if err := st.checkSeal(); err != nil {
+		log.Print(msg("store-damaged", err))
+	}
+	newest, err := st.newest()
+	check(err)
+	initRun = newest.IsZero()
+	if initRun {
+		defer setColorFallback(st)
+	}
+	// With -no-backfill gaps are ignored, only a date newer than the stored ones is due.
+	lastDate = newest
+	if !*noBackfill {
+		lastDate, err = readLastDate(st)
 		check(err)
-		if lastDate == today {
-			os.Exit(0)
-		}
+	}
+	// The newest wallpaper is set if the previous run couldn't or wasn't allowed to by -set-window,
+	// also when a newer one isn't published yet.
+	explain("newest stored date %s, complete up to %s, today %s", newest.Format(localDateLayout),
+		lastDate.Format(localDateLayout), today.Format(localDateLayout))
+	retryUnset(st, newest)
+	// If today's wallpaper is already stored, exit. Gaps left by failed dates are retried by the first
+	// run of the next day, gaps left by -max-per-run by the next run.
+	if !newest.Before(today) && (*maxPerRun == 0 || lastDate.Equal(newest)) {
+		return
 	}
 	if lastDate.IsZero() {
 		lastDate = yesterday
 	}
+	// Without gaps, nothing is to be done if the listing hasn't changed since the previous run which
+	// left nothing to do, so the listing isn't fetched and parsed.
+	if lastDate.Equal(newest) && !listingChanged() {
+		return
+	}
 
-	// Page with thumbs.
-	response, err := getResponse(startURL)
-	check(err)
-	defer response.Body.Close()
-	root, err := goquery.NewDocumentFromReader(response.Body)
+	// Collect links until the last date, skipping dates which are stored already. Older listing pages
+	// are read as long as the gap goes on.
+	listing, err := listingSince(lastDate)
+	if err != nil && runCtx.Err() != nil {
+		log.Print(msg("deadline", *deadline, stats.downloaded, stats.failures))
+		return
+	}
+	if errors.Is(err, errFetch) {
+		stats.failures++
+		stats.incomplete = true
+		stats.listingFailed = true
+		log.Print(msg("listing-failed", err))
+		return
+	}
 	check(err)
-
-	thumbs := root.Find("ul.imglist > li")
-	if thumbs.Length() == 0 {
-		log.Panicf("Could not find thumbs")
+	links := make([]link, 0)
+	// Skipped dates become due later, so the listing has to be parsed again even if it's unchanged.
+	skipped := false
+	for _, l := range listing {
+		if !l.date.After(lastDate) {
+			break
+		}
+		// Tomorrow date may exist but attempt to download wallpaper returns error 404.
+		if l.date.After(today.AddDate(0, 0, *futureDays)) {
+			skipped = true
+			continue
+		}
+		// Stored dates are skipped, unless their files are missing or corrupt.
+		e, stored, err := st.get(l.date)
+		check(err)
+		if stored {
+			if err = checkImage(e); err == nil {
+				continue
+			}
+			log.Print(msg("repairing", err))
+		}
+		links = append(links, l)
+		if *noBackfill {
+			break
+		}
 	}
 
-	// Collect urls until the last date.
-	urls := make([]string, 0)
-	thumbs.EachWithBreak(func(i int, thumb *goquery.Selection) bool {
-		dateStr := thumb.Find("time").First().Text()
-		date, err := time.Parse(remoteDateLayout, dateStr)
-		check(err)
+	stats.dates = len(links)
 
-		if !date.After(lastDate) {
-			return false
+	// If there is a new link, it is set, the others are only downloaded and logged. Runs continuing
+	// -max-per-run have missed dates only.
+	var failed time.Time
+	newestDone := true
+	hasNewest := len(links) > 0 && links[0].date.After(newest)
+	missed := links
+	if hasNewest {
+		missed = links[1:]
+	}
+	if len(links) > 0 {
+		// With -set-first the desktop updates without waiting for the backfill. lastDate is saved
+		// before, so an interrupted backfill is resumed although the newest date is stored.
+		setFirst := *setFirst && hasNewest && len(missed) > 0
+		if setFirst {
+			check(writeLastDate(lastDate))
+			newestDone, err = processNewest(links[0])
+			check(err)
 		}
-		// Tomorrow date may exist but attempt to download wallpaper returns error 404.
-		if date.After(today) {
-			return true
+		// With -max-per-run the oldest missed dates are downloaded, the newer ones are left to the
+		// next runs like failed ones.
+		var deferred time.Time
+		if *maxPerRun > 0 && len(missed) > *maxPerRun {
+			deferred = missed[len(missed)-*maxPerRun-1].date
+			missed = missed[len(missed)-*maxPerRun:]
+		}
+		failed, err = backfill(missed)
+		check(err)
+		if failed.IsZero() {
+			failed = deferred
+		}
+		// Gaps left by runs with backfill are kept for them.
+		if !*noBackfill {
+			if failed.IsZero() {
+				err = writeLastDate(time.Time{})
+			} else {
+				err = writeLastDate(failed.AddDate(0, 0, -1))
+			}
+			check(err)
+		}
+		if hasNewest && !setFirst {
+			newestDone, err = processNewest(links[0])
+			check(err)
+		}
+		if hasNewest && !newestDone && *fallbackLatest && runCtx.Err() == nil {
+			setFallback(st, newest)
 		}
+	}
+
+	if runCtx.Err() != nil {
+		log.Print(msg("deadline", *deadline, stats.downloaded, stats.failures))
+		return
+	}
+	if newestDone && failed.IsZero() && !skipped {
+		check(saveListingValidators())
+	}
+}
 
-		href, ok := thumb.Find("a").First().Attr("href")
-		if !ok {
-			log.Panicf("Could not find url at date %s", date.Format(localDateLayout))
+// Download and log historical wallpapers from the oldest. Returns the oldest failed date, zero if
+// all succeeded. The archive is complete up to the day before it, so until that date is
+// downloaded, lastDate stays there, even though newer dates are stored. Failures of the store stop
+// it with the error.
+func backfill(links []link) (time.Time, error) {
+	var failed time.Time
+	for i := len(links) - 1; i >= 0; i-- {
+		// After the deadline the remaining dates are left to the next run.
+		if runCtx.Err() != nil {
+			if failed.IsZero() {
+				failed = links[i].date
+			}
+			break
+		}
+		wp, err := downloadWallpaper(links[i].url)
+		if errors.Is(err, errStore) {
+			return failed, err
+		}
+		if err != nil {
+			// For historical wallpapers it's not fatal.
+			log.Println(err)
+			stats.failures++
+			if failed.IsZero() {
+				failed = links[i].date
+			}
+			continue
+		}
+		stats.downloaded++
+		if err = logWallpaper(wp); err != nil {
+			return failed, err
 		}
-		url := baseURL + href
-		urls = append(urls, url)
+	}
+	return failed, nil
+}
 
-		return true
-	})
+// Download the newest wallpaper, set it, unless -no-set, and log it. Returns false if it has to be
+// retried by the next run, and an error if the run has to stop.
+func processNewest(l link) (bool, error) {
+	wp, err := downloadWallpaper(l.url)
+	if runCtx.Err() != nil {
+		return false, nil
+	}
+	// The newest wallpaper may be listed before it is published, and the site may be unreachable for
+	// a while, it is retried by the next run like one failing the limits. Changed markup and a
+	// failing store stop the run, every further run would fail the same way.
+	date := l.date.Format(localDateLayout)
+	switch {
+	case err == nil:
+	case errors.Is(err, errNotAvailable):
+		log.Print(msg("not-available", date, err))
+		return false, nil
+	case errors.Is(err, errParse), errors.Is(err, errStore):
+		stats.failures++
+		return false, err
+	case errors.Is(err, errFetch):
+		stats.failures++
+		stats.incomplete = true
+		log.Print(msg("fetch-failed", date, err))
+		return false, nil
+	case errors.Is(err, errTooSmall):
+		stats.failures++
+		log.Print(msg("too-small-discarded", err))
+		return false, nil
+	default:
+		stats.failures++
+		log.Print(msg("newest-failed", date, err))
+		return false, nil
+	}
+	stats.downloaded++
+	if err = setNewest(wp); err != nil {
+		return false, err
+	}
+	return true, logWallpaper(wp)
+}
 
-	// If there are new urls, range them from last to first.
-	if len(urls) > 0 {
-		// Except first: only download and log.
-		for i := len(urls) - 1; i > 0; i-- {
-			date, filename, title, description, err := downloadWallpaper(urls[i])
+// Set the newest wallpaper, unless -no-set, -no-set-on-init or -set-window say otherwise. A
+// wallpaper which isn't set is only logged, the error is of saving that for the next runs.
+func setNewest(wp wallpaper) error {
+	if initRun && *noSetOnInit && !*noSet {
+		log.Print(msg("init-not-set", wp.filename))
+	} else if !*noSet {
+		if !setWindowRange.contains(time.Now()) {
+			log.Print(msg("set-deferred", wp.filename, *setWindow))
+			return writeUnset(wp.date, true)
+		} else if bigEnough(wp.width, wp.height) {
+			// A wallpaper which couldn't be set is retried by the next runs, see retryUnset.
+			err := setWallpaper(wp)
 			if err != nil {
-				// For historical wallpapers it's not fatal.
-				log.Println(err)
-				continue
+				log.Print(err)
 			}
-			logWallpaper(date, filename, title, description)
+			return writeUnset(wp.date, err != nil)
+		} else {
+			log.Print(msg("too-small", wp.filename, wp.width, wp.height))
 		}
-		// For the first url further set wallpaper and output message.
-		date, filename, title, description, err := downloadWallpaper(urls[0])
-		// For the first wallpaper error is fatal.
-		check(err)
-		setWallpaper(filename, title, description)
-		logWallpaper(date, filename, title, description)
 	}
+	return nil
+}
+
+// With -fallback-latest, set the newest stored wallpaper when the newest listed one couldn't be
+// downloaded, if it was stored by this run, e.g. by the backfill after days offline. Otherwise it
+// has been set already. The missing date stays due.
+func setFallback(st *store, previous time.Time) {
+	entries, err := st.entries()
+	check(err)
+	if len(entries) == 0 || !entries[0].date.After(previous) {
+		return
+	}
+	log.Print(msg("fallback", entries[0].filename))
+	check(setNewest(entries[0].wallpaper()))
 }
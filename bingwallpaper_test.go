@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Set the flag or variable for the test only.
+func setFlag[T any](t *testing.T, p *T, value T) {
+	t.Helper()
+	old := *p
+	*p = value
+	t.Cleanup(func() { *p = old })
+}
+
+// Point imgDir, wpFile and the sink to a temporary directory and start with empty stats.
+func useTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	setFlag(t, &imgDir, dir)
+	setFlag(t, &wpFile, filepath.Join(dir, "wallpapers"))
+	setFlag[imageSink](t, &sink, &localSink{dir: dir})
+	setFlag(t, &stats, runStats{})
+	return dir
+}
+
+func mustParseDate(t *testing.T, s string) time.Time {
+	t.Helper()
+	date, err := time.Parse(localDateLayout, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return date
+}
+
+// JPEG of the dimensions.
+func testJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// Transport sending the requests for any host to the test server.
+type siteTransport struct {
+	host string
+}
+
+func (t siteTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.URL.Scheme, request.URL.Host = "http", t.host
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+// The site in the markup the selectors expect: the listing at startURL links the dates, newest
+// first, to their transitional pages /day/<date>.html, which link to the detail pages
+// /detail/<date>.html showing the images /img/<date>.jpg. Tests add pages of their own to mux.
+type fakeSite struct {
+	mux   *http.ServeMux
+	dates []string
+	image []byte
+	// Serves the listing instead of the single page of dates if set.
+	listing http.HandlerFunc
+
+	mu sync.Mutex
+	// Dates whose image fails with status 500.
+	broken map[string]bool
+	// Number of requests by path.
+	requests map[string]int
+}
+
+// Serve the site for the test, reached by httpClient through the urls of the real one.
+func newFakeSite(t *testing.T, dates ...string) *fakeSite {
+	t.Helper()
+	site := &fakeSite{
+		mux:      http.NewServeMux(),
+		dates:    dates,
+		image:    testJPEG(t, 64, 36),
+		broken:   make(map[string]bool),
+		requests: make(map[string]int),
+	}
+	site.mux.HandleFunc("/list/new/desc/classic.html", func(w http.ResponseWriter, r *http.Request) {
+		if site.listing != nil {
+			site.listing(w, r)
+			return
+		}
+		var b strings.Builder
+		b.WriteString(`<html><body><ul class="imglist">`)
+		for _, date := range site.dates {
+			fmt.Fprintf(&b, `<li><a href="/day/%s.html"><time>%s</time></a></li>`, date, remoteDate(t, date))
+		}
+		b.WriteString(`</ul></body></html>`)
+		w.Write([]byte(b.String()))
+	})
+	site.mux.HandleFunc("/day/{page}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><body><a class="fl" href="/detail/%s">Download</a></body></html>`, r.PathValue("page"))
+	})
+	site.mux.HandleFunc("/detail/{page}", func(w http.ResponseWriter, r *http.Request) {
+		date := strings.TrimSuffix(r.PathValue("page"), ".html")
+		fmt.Fprintf(w, `<html><head><title>Wallpaper %s</title></head><body><div class="detail">
+<time itemprop="date">%s</time>
+<div class="title">Title %s © Author</div>
+<div class="description">Description of %s</div>
+<img id="bing_wallpaper" src="/img/%s.jpg">
+</div></body></html>`, date, remoteDate(t, date), date, date, date)
+	})
+	site.mux.HandleFunc("/img/{name}", func(w http.ResponseWriter, r *http.Request) {
+		site.mu.Lock()
+		broken := site.broken[strings.TrimSuffix(r.PathValue("name"), ".jpg")]
+		site.mu.Unlock()
+		if broken {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		w.Write(site.image)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site.mu.Lock()
+		site.requests[r.URL.Path]++
+		site.mu.Unlock()
+		site.mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	setFlag(t, &httpClient, &http.Client{Transport: siteTransport{host: server.Listener.Addr().String()}})
+	return site
+}
+
+// Date of the listing and the detail pages.
+func remoteDate(t *testing.T, date string) string {
+	return mustParseDate(t, date).Format(remoteDateLayout)
+}
+
+// A run holds lastDate back at the day before a failed date, which the first run of the next day
+// retries although newer dates are stored.
+func TestRunHoldsLastDateAtFailedDate(t *testing.T) {
+	useTempDir(t)
+	setFlag(t, noSet, true)
+	setFlag(t, &lastDate, time.Time{})
+	setFlag(t, &initRun, false)
+	setFlag(t, &today, mustParseDate(t, "20240105"))
+	setFlag(t, &yesterday, mustParseDate(t, "20240104"))
+	site := newFakeSite(t, "20240105", "20240104", "20240103", "20240102", "20240101")
+	if err := (&store{path: wpFile}).add(entry{date: mustParseDate(t, "20240101"), filename: "old.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(imgDir, "old.jpg"), site.image, 0644); err != nil {
+		t.Fatal(err)
+	}
+	site.broken["20240103"] = true
+
+	run()
+	st := &store{path: wpFile}
+	entries, err := st.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The dates around the failed one are stored, still sorted from the newest.
+	if got, want := entryDates(entries), "20240105 20240104 20240102 20240101"; got != want {
+		t.Errorf("stored dates %s, want %s", got, want)
+	}
+	last, err := readLastDate(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mustParseDate(t, "20240102"); !last.Equal(want) {
+		t.Errorf("last date %s, want %s", last.Format(localDateLayout), want.Format(localDateLayout))
+	}
+
+	// The next day the failed date is retried, and the archive is complete.
+	site.broken["20240103"] = false
+	setFlag(t, &today, mustParseDate(t, "20240106"))
+	setFlag(t, &yesterday, mustParseDate(t, "20240105"))
+	run()
+	if entries, err = st.entries(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entryDates(entries), "20240105 20240104 20240103 20240102 20240101"; got != want {
+		t.Errorf("stored dates after the retry %s, want %s", got, want)
+	}
+	if last, err = readLastDate(st); err != nil {
+		t.Fatal(err)
+	}
+	if want := mustParseDate(t, "20240105"); !last.Equal(want) {
+		t.Errorf("last date after the retry %s, want the newest %s", last.Format(localDateLayout), want.Format(localDateLayout))
+	}
+	if n := site.requests["/img/20240104.jpg"]; n != 1 {
+		t.Errorf("stored date downloaded %d times, want once", n)
+	}
+}
+
+// Restore the paths computed by initPaths after the test.
+func keepPaths(t *testing.T) {
+	t.Helper()
+	setFlag(t, &imgDir, imgDir)
+	setFlag(t, &wpFile, wpFile)
+	setFlag(t, &imgDirSource, imgDirSource)
+	setFlag(t, &wpFileSource, wpFileSource)
+	setFlag(t, &sink, sink)
+	for _, p := range []*string{homeDir, dirArg, wpArg, trashDirArg, saveHTMLDir, metricsFile, captionFile, copyTo} {
+		setFlag(t, p, *p)
+	}
+}
+
+func TestInitPaths(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		home       string
+		homeFlag   string
+		dir        string
+		wpFile     string
+		wantImgDir string
+		wantWpFile string
+	}{
+		{"home", "/home/u", "", "", "", "/home/u/Images/bing-wallpapers", "/home/u/Images/bing-wallpapers/wallpapers"},
+		{"-home", "/home/u", "/srv/bw", "", "", "/srv/bw/Images/bing-wallpapers", "/srv/bw/Images/bing-wallpapers/wallpapers"},
+		{"-home without HOME", "", "/srv/bw", "", "", "/srv/bw/Images/bing-wallpapers", "/srv/bw/Images/bing-wallpapers/wallpapers"},
+		{"-dir", "/home/u", "/srv/bw", "/data/wp", "", "/data/wp", "/data/wp/wallpapers"},
+		{"-wp-file", "/home/u", "", "", "/var/lib/wp.txt", "/home/u/Images/bing-wallpapers", "/var/lib/wp.txt"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			keepPaths(t)
+			t.Setenv("HOME", test.home)
+			*homeDir, *dirArg, *wpArg = test.homeFlag, test.dir, test.wpFile
+			if err := initPaths(); err != nil {
+				t.Fatal(err)
+			}
+			if imgDir != test.wantImgDir || wpFile != test.wantWpFile {
+				t.Errorf("paths %s and %s, want %s and %s", imgDir, wpFile, test.wantImgDir, test.wantWpFile)
+			}
+		})
+	}
+}
+
+func TestInitPathsWithoutHome(t *testing.T) {
+	keepPaths(t)
+	t.Setenv("HOME", "")
+	*homeDir, *dirArg, *wpArg = "", "", ""
+	if err := initPaths(); err == nil {
+		t.Errorf("no error without $HOME, -home and -dir, image directory %s", imgDir)
+	}
+}
+
+func TestInitPathsHomeFromEnv(t *testing.T) {
+	keepPaths(t)
+	setFlag(t, &fromEnv, make(map[string]string))
+	t.Setenv("HOME", "/home/u")
+	t.Setenv("BINGWALLPAPER_HOME", "/srv/bw")
+	*homeDir, *dirArg, *wpArg = "", "", ""
+	if err := applyEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if err := initPaths(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/srv/bw/Images/bing-wallpapers"; imgDir != want {
+		t.Errorf("image directory %s, want %s", imgDir, want)
+	}
+	if want := "$BINGWALLPAPER_HOME"; imgDirSource != want {
+		t.Errorf("image directory from %s, want %s", imgDirSource, want)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	t.Setenv("HOME", "/home/u")
+	t.Setenv("WP", "/data/wp")
+	for _, test := range []struct {
+		path string
+		want string
+	}{
+		{"~", "/home/u"},
+		{"~/sub", "/home/u/sub"},
+		{"$HOME/sub", "/home/u/sub"},
+		{"${WP}/2024", "/data/wp/2024"},
+		{"/abs/path", "/abs/path"},
+		{"rel/~/path", "rel/~/path"},
+		{"~other/sub", "~other/sub"},
+		{"", ""},
+	} {
+		if got := expandPath(test.path); got != test.want {
+			t.Errorf("expandPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestFetchPage(t *testing.T) {
+	useTempDir(t)
+	site := newFakeSite(t, "20240101")
+	site.mux.HandleFunc("/failing.html", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failing", http.StatusInternalServerError)
+	})
+	for _, test := range []struct {
+		path string
+		want error
+	}{
+		{"/day/20240101.html", nil},
+		{"/failing.html", errFetch},
+		{"/missing.html", errNotAvailable},
+	} {
+		root, err := fetchPage(baseURL+test.path, "transitional")
+		switch {
+		case test.want == nil && err != nil:
+			t.Errorf("%s: %v", test.path, err)
+		case test.want == nil && root.Find("a.fl").Length() != 1:
+			t.Errorf("%s: link to the detail page not parsed", test.path)
+		case test.want != nil && !errors.Is(err, test.want):
+			t.Errorf("%s: error %v, want %v", test.path, err, test.want)
+		}
+	}
+	if stats.pageBytes == 0 {
+		t.Error("bytes of the pages not counted")
+	}
+}
+
+func TestFetchPageSavesHTML(t *testing.T) {
+	dir := useTempDir(t)
+	newFakeSite(t, "20240101")
+	setFlag(t, saveHTMLDir, filepath.Join(dir, "html"))
+	setFlag(t, &previousPages, make(map[string][]byte))
+	if _, err := fetchPage(baseURL+"/detail/20240101.html", "detail"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "html", "detail-detail-20240101.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `id="bing_wallpaper"`) {
+		t.Errorf("saved page is not the detail page:\n%s", data)
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	const page = "https://bing.gifposter.com/list/new/desc/classic.html"
+	for _, test := range []struct {
+		href string
+		want string
+	}{
+		{"https://cdn.example.com/img/a.jpg", "https://cdn.example.com/img/a.jpg"},
+		{"//cdn.example.com/img/a.jpg", "https://cdn.example.com/img/a.jpg"},
+		{"/detail/lake.html", "https://bing.gifposter.com/detail/lake.html"},
+		{"lake.html", "https://bing.gifposter.com/list/new/desc/lake.html"},
+		{"../../old/lake.html", "https://bing.gifposter.com/list/old/lake.html"},
+		{"?page=2", "https://bing.gifposter.com/list/new/desc/classic.html?page=2"},
+		{"  /detail/lake.html\n", "https://bing.gifposter.com/detail/lake.html"},
+	} {
+		got, err := resolveURL(page, test.href)
+		if err != nil || got != test.want {
+			t.Errorf("resolveURL(%q) = %q, %v, want %q", test.href, got, err, test.want)
+		}
+	}
+	if _, err := resolveURL(page, "http://[::1"); !errors.Is(err, errParse) {
+		t.Errorf("malformed link: %v, want %v", err, errParse)
+	}
+}
+
+func TestEnsureImgDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("not a directory"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	readOnly := filepath.Join(dir, "read-only")
+	if err := os.Mkdir(readOnly, 0555); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{"missing with parents", filepath.Join(dir, "a", "b", "c"), ""},
+		{"existing", dir, ""},
+		{"regular file", file, "is not a directory"},
+		{"file as parent", filepath.Join(file, "sub"), "not a directory"},
+		{"read-only", readOnly, "is not writable"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.name == "read-only" && os.Getuid() == 0 {
+				t.Skip("root writes into read-only directories")
+			}
+			setFlag(t, &imgDir, test.path)
+			err := ensureImgDir()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info, err := os.Stat(test.path); err != nil || !info.IsDir() {
+					t.Errorf("%s is no directory: %v", test.path, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("error %v, want one saying %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestDownloadCountsBytes(t *testing.T) {
+	dir := useTempDir(t)
+	site := newFakeSite(t, "20240101")
+	site.image = testJPEG(t, 320, 180)
+	wp, err := downloadWallpaper(baseURL + "/day/20240101.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.bytes != int64(len(site.image)) {
+		t.Errorf("image bytes %d, want the size of the image %d", stats.bytes, len(site.image))
+	}
+	info, err := os.Stat(filepath.Join(dir, wp.filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != stats.bytes {
+		t.Errorf("saved %d bytes, counted %d", info.Size(), stats.bytes)
+	}
+	if stats.pageBytes == 0 || wp.bytes != stats.bytes+stats.pageBytes {
+		t.Errorf("bytes of the date %d, want image %d and pages %d", wp.bytes, stats.bytes, stats.pageBytes)
+	}
+	if wp.width != 320 || wp.height != 180 {
+		t.Errorf("dimensions %dx%d, want 320x180", wp.width, wp.height)
+	}
+}
+
+// A download failing the limits leaves the stored image of the date alone, e.g. on redownload.
+func TestRejectedDownloadKeepsImage(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		setup func(t *testing.T, site *fakeSite)
+		want  error
+	}{
+		{"too small", func(t *testing.T, site *fakeSite) {
+			setFlag(t, discardSmall, true)
+			setFlag(t, minWidth, 1920)
+		}, errTooSmall},
+		{"too large without Content-Length", func(t *testing.T, site *fakeSite) {
+			setFlag(t, maxImageSize, int64(len(site.image)-1))
+			site.mux.HandleFunc("/img/20240101.jpg", func(w http.ResponseWriter, r *http.Request) {
+				w.Write(site.image[:10])
+				w.(http.Flusher).Flush()
+				w.Write(site.image[10:])
+			})
+		}, errTooLarge},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := useTempDir(t)
+			site := newFakeSite(t, "20240101")
+			stored := []byte("stored image")
+			if err := os.WriteFile(filepath.Join(dir, "20240101.jpg"), stored, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := (&store{path: wpFile}).add(entry{date: mustParseDate(t, "20240101"), filename: "20240101.jpg"}); err != nil {
+				t.Fatal(err)
+			}
+			test.setup(t, site)
+
+			if _, err := downloadWallpaper(baseURL + "/day/20240101.html"); !errors.Is(err, test.want) {
+				t.Fatalf("error %v, want %v", err, test.want)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "20240101.jpg"))
+			if err != nil || !bytes.Equal(data, stored) {
+				t.Errorf("stored image replaced: %q, %v", data, err)
+			}
+			files, _ := filepath.Glob(filepath.Join(dir, ".20240101.jpg-*"))
+			if len(files) > 0 {
+				t.Errorf("temporary files left: %v", files)
+			}
+		})
+	}
+}
+
+// A message which can't be shown, e.g. without zenity, neither fails setting nor logging.
+func TestNewestLoggedWithoutNotifier(t *testing.T) {
+	useTempDir(t)
+	newFakeSite(t, "20240101")
+	fakePrograms(t, "feh")
+	setFlag(t, headlessArg, "no")
+	setFlag(t, noSet, false)
+	setFlag(t, &initRun, false)
+	s, err := findSetter("feh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A copy, as setting changes the environment of the setter.
+	feh := *s
+	setFlag(t, &wallpaperSetter, &feh)
+
+	done, err := processNewest(link{date: mustParseDate(t, "20240101"), url: baseURL + "/day/20240101.html"})
+	if !done || err != nil {
+		t.Fatalf("done %v, error %v", done, err)
+	}
+	if !stats.set || stats.failures != 0 {
+		t.Errorf("set %v with %d failures, want set without failures", stats.set, stats.failures)
+	}
+	if _, stored, err := (&store{path: wpFile}).get(mustParseDate(t, "20240101")); !stored || err != nil {
+		t.Errorf("wallpaper not logged: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+)
+
+// Tile the stored wallpapers of the inclusive date range into one image, a row of -cols cells per
+// line, oldest first. Missing or broken images leave their cell empty.
+func collage(args []string) error {
+	flags := flag.NewFlagSet("collage", flag.ExitOnError)
+	sinceStr := flags.String("since", "", "first date, YYYYMMDD (required)")
+	untilStr := flags.String("until", today.Format(localDateLayout), "last date, YYYYMMDD")
+	cols := flags.Int("cols", 7, "cells per row")
+	cellArg := flags.String("cell", "320x180", "size of a cell, WxH in pixels; images are cropped to its shape")
+	bgArg := flags.String("bg", "#000000", "background color between the cells, #RRGGBB")
+	labels := flags.Bool("labels", false, "draw the date into each cell")
+	out := flags.String("out", "collage.jpg", "image written, PNG if it ends in .png, JPEG otherwise")
+	flags.Parse(args)
+
+	if *sinceStr == "" {
+		return fmt.Errorf("collage: -since is required")
+	}
+	since, err := time.Parse(localDateLayout, *sinceStr)
+	if err != nil {
+		return fmt.Errorf("collage: malformed -since: %s", err)
+	}
+	until, err := time.Parse(localDateLayout, *untilStr)
+	if err != nil {
+		return fmt.Errorf("collage: malformed -until: %s", err)
+	}
+	if until.Before(since) {
+		return fmt.Errorf("collage: -until is before -since")
+	}
+	if *cols < 1 {
+		return fmt.Errorf("collage: -cols must be at least 1")
+	}
+	var cellWidth, cellHeight int
+	if n, _ := fmt.Sscanf(*cellArg, "%dx%d", &cellWidth, &cellHeight); n != 2 || cellWidth < 1 || cellHeight < 1 {
+		return fmt.Errorf("collage: malformed -cell %q, expected WxH, e.g. 320x180", *cellArg)
+	}
+	bg, err := parseHexColor(*bgArg)
+	if err != nil {
+		return fmt.Errorf("collage: %s", err)
+	}
+
+	entries, err := (&store{path: wpFile}).entries()
+	if err != nil {
+		return err
+	}
+	entries = slices.DeleteFunc(entries, func(e entry) bool {
+		return e.date.Before(since) || e.date.After(until)
+	})
+	if len(entries) == 0 {
+		return fmt.Errorf("collage: no wallpapers are stored from %s to %s", *sinceStr, *untilStr)
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return a.date.Compare(b.date) })
+
+	// Cells are separated and surrounded by a gap of the background.
+	gap := max(cellHeight/30, 2)
+	rows := (len(entries) + *cols - 1) / *cols
+	width := min(len(entries), *cols)*(cellWidth+gap) + gap
+	height := rows*(cellHeight+gap) + gap
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	var face font.Face
+	if *labels {
+		if face, err = captionFace(float64(cellHeight) / 10); err != nil {
+			return err
+		}
+		defer face.Close()
+	}
+	for i, e := range entries {
+		col, row := i%*cols, i / *cols
+		x, y := gap+col*(cellWidth+gap), gap+row*(cellHeight+gap)
+		cell := image.Rect(x, y, x+cellWidth, y+cellHeight)
+		if err := drawCell(canvas, cell, filepath.Join(imgDir, e.filename)); err != nil {
+			log.Println(err)
+		}
+		if face != nil {
+			drawCaption(canvas, cell, face, []string{e.date.Format("2006-01-02")}, "bottom-left", 1)
+		}
+	}
+
+	var b bytes.Buffer
+	if strings.EqualFold(filepath.Ext(*out), ".png") {
+		err = png.Encode(&b, canvas)
+	} else {
+		err = jpeg.Encode(&b, canvas, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		return fmt.Errorf("Could not encode the collage: %s", err)
+	}
+	if err = writeFileAtomic(*out, b.Bytes(), 0644); err != nil {
+		return fmt.Errorf("Could not write %s: %s", *out, err)
+	}
+	fmt.Println(msg("collage", len(entries), *out))
+	return nil
+}
+
+// Scale the image at path into the cell, cropped centered to the cell's shape.
+func drawCell(canvas *image.RGBA, cell image.Rectangle, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("Could not decode %s: %s", path, err)
+	}
+	draw.ApproxBiLinear.Scale(canvas, cell, img, coverRect(img.Bounds(), cell), draw.Src, nil)
+	return nil
+}
+
+// Color given as #RRGGBB, the # being optional.
+func parseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("malformed color %q, expected #RRGGBB", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Command given after the global flags: bingwallpaper [flags] command [command flags].
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"apply", "set any image, with -title and -description for the message", apply},
+	{"check", "report missing, corrupt and mismatched images and orphaned files without changing anything", checkStore},
+	{"collage", "tile thumbnails of the wallpapers of a date range into one image", collage},
+	{"config", "print the effective configuration and where each value comes from", printConfig},
+	{"convert-store", "write the wallpapers file into a new file in another -store-format", convertStore},
+	{"empty-trash", "permanently remove images moved into the trash directory", emptyTrash},
+	{"fetch", "download and log wallpapers of a date range without setting them", fetch},
+	{"import", "add images of an existing directory to the wallpapers file, dated by their names", importImages},
+	{"install-cron", "add a daily crontab entry running the script with the current flags", installCron},
+	{"list", "print stored wallpapers with their source urls", list},
+	{"markets", "print known Bing market codes accepted by -market", listMarkets},
+	{"next", "set a random stored wallpaper", next},
+	{"open", "open the page of the newest or the given date's wallpaper in the browser", openPage},
+	{"probe-setter", "set a generated test image with the configured setter and report the result", probeSetter},
+	{"prune-orphans", "list image files which aren't in the wallpapers file, -delete removes them", pruneOrphans},
+	{"redownload", "download the images of the given dates, or of all missing and corrupt ones, again", redownload},
+	{"reparse", "parse title, description, author and location of stored wallpapers again without downloading images", reparse},
+	{"set", "set wallpapers given by path or date as arguments or on stdin", setImages},
+	{"setters", "list built-in setters, whether their programs are installed and the fit modes they support", listSetters},
+	{"show", "print the stored record of a date", show},
+	{"uninstall-cron", "remove the crontab entry added by install-cron", uninstallCron},
+	{"url", "print the image url of today's or the given date's wallpaper without downloading it", printURL},
+	{"verify", "check that stored images exist, decode and match their checksums", verify},
+	{"watch", "set the newest wallpaper again whenever monitors are plugged in or out", watchMonitors},
+}
+
+func findCommand(name string) (command, error) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, nil
+		}
+	}
+	return command{}, fmt.Errorf("Unknown command %q, run with -h to list commands", name)
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, msg("usage", os.Args[0])+"\n")
+	fmt.Fprintln(out, msg("usage-default"))
+	fmt.Fprintln(out, "\n"+msg("usage-commands"))
+	sorted := append([]command(nil), commands...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+	for _, c := range sorted {
+		fmt.Fprintf(out, "  %-16s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(out, "\n"+msg("usage-flags"))
+	flag.PrintDefaults()
+}
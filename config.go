@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// Setting of the effective configuration and where its value comes from.
+type setting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Resolved settings: paths, urls and programs the run would use, followed by all flags.
+func effectiveConfig() []setting {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	source := func(name string) string {
+		if variable, ok := fromEnv[name]; ok {
+			return "$" + variable
+		}
+		if explicit[name] {
+			return "flag"
+		}
+		return "default"
+	}
+
+	settings := []setting{
+		{"img-dir", imgDir, imgDirSource},
+		{"wp-file", wpFile, wpFileSource},
+		{"base-url", baseURL, "built-in"},
+		{"start-url", startURL, "built-in"},
+	}
+	if *marketArg == "" {
+		settings = append(settings, setting{"market", "source default", "default"})
+	} else {
+		settings = append(settings, setting{"market", *marketArg, flagSource("market")})
+	}
+	noSetSource := flagSource("no-set")
+	if *setterName == "none" {
+		noSetSource = flagSource("setter")
+	}
+	switch {
+	case *noSet:
+		settings = append(settings, setting{"setter", "none", noSetSource})
+	case *setScript != "":
+		settings = append(settings, setting{"setter", *setScript, flagSource("set-script")})
+	case *setterCmd != "":
+		settings = append(settings, setting{"setter", *setterCmd, flagSource("setter-cmd")})
+	case *setterName == "auto":
+		settings = append(settings, setting{"setter", wallpaperSetter.name, "detected"})
+	default:
+		settings = append(settings, setting{"setter", wallpaperSetter.name, flagSource("setter")})
+	}
+	if *noSet {
+		settings = append(settings, setting{"notifier", "none", noSetSource})
+	} else {
+		settings = append(settings, setting{"notifier", "zenity", "built-in"})
+	}
+
+	headless := setting{"headless", "no", "detected"}
+	if isHeadless() {
+		headless.Value = "yes"
+	}
+	if *headlessArg != "auto" {
+		headless.Source = flagSource("headless")
+	}
+	settings = append(settings, headless)
+
+	flag.VisitAll(func(f *flag.Flag) {
+		settings = append(settings, setting{"-" + f.Name, f.Value.String(), source(f.Name)})
+	})
+	return settings
+}
+
+// Print the effective configuration. It has no side effects.
+func printConfig(args []string) error {
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	settings := effectiveConfig()
+	if *asJSON {
+		return printJSON(settings)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, s := range settings {
+		fmt.Fprintf(w, "%s\t%s\t(%s)\n", s.Name, s.Value, s.Source)
+	}
+	return w.Flush()
+}
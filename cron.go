@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Marker comment identifying the crontab entry managed by install-cron and uninstall-cron.
+const cronMarker = "# bingwallpaper"
+
+// Add a crontab entry running the executable with the flags of the current invocation.
+func installCron(args []string) error {
+	flags := flag.NewFlagSet("install-cron", flag.ExitOnError)
+	schedule := flags.String("schedule", "15 9 * * *", "cron schedule, daily by default; runs are cheap when the wallpaper is up to date")
+	flags.Parse(args)
+
+	lines, err := readCrontab()
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if strings.HasSuffix(line, cronMarker) {
+			return fmt.Errorf("Crontab already has the entry, run uninstall-cron first:\n%s", line)
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("Could not find the executable: %s", err)
+	}
+	entry := []string{*schedule}
+	if !*noSet {
+		display := os.Getenv("DISPLAY")
+		if display == "" {
+			display = ":0"
+		}
+		entry = append(entry, "DISPLAY="+shellQuote(display))
+	}
+	entry = append(entry, shellQuote(executable))
+	entry = append(entry, cronFlags()...)
+	// Unescaped % is a line break for cron.
+	line := strings.ReplaceAll(strings.Join(entry, " "), "%", `\%`)
+
+	return writeCrontab(append(lines, line+" "+cronMarker))
+}
+
+// Flags given on the command line, quoted for the crontab entry. Flags set from $BINGWALLPAPER_*
+// are left out, cron runs with the environment it has.
+func cronFlags() []string {
+	args := make([]string, 0)
+	flag.Visit(func(f *flag.Flag) {
+		if _, ok := fromEnv[f.Name]; !ok {
+			args = append(args, shellQuote(fmt.Sprintf("-%s=%s", f.Name, f.Value)))
+		}
+	})
+	return args
+}
+
+// Remove the crontab entry added by installCron.
+func uninstallCron(args []string) error {
+	flags := flag.NewFlagSet("uninstall-cron", flag.ExitOnError)
+	flags.Parse(args)
+
+	lines, err := readCrontab()
+	if err != nil {
+		return err
+	}
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if !strings.HasSuffix(line, cronMarker) {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == len(lines) {
+		return fmt.Errorf("Crontab has no entry added by install-cron")
+	}
+	return writeCrontab(kept)
+}
+
+// Lines of the current user's crontab. Missing crontab is empty.
+func readCrontab() ([]string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("crontab", "-l")
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "no crontab") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("crontab -l failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	text := strings.TrimRight(string(output), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	return strings.Split(text, "\n"), nil
+}
+
+// Install the lines as the current user's crontab and print it for confirmation.
+func writeCrontab(lines []string) error {
+	text := strings.Join(lines, "\n") + "\n"
+	cmd := exec.Command("crontab", "-")
+	cmd.Stdin = strings.NewReader(text)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("crontab - failed: %s: %s", err, strings.TrimSpace(string(output)))
+	}
+	fmt.Print(text)
+	return nil
+}
+
+// Quote the string for sh if it has special characters.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=.,/:@+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCronFlagsLeaveOutEnvironment(t *testing.T) {
+	setFlag(t, &fromEnv, make(map[string]string))
+	setFlag(t, quiet, *quiet)
+	setFlag(t, rps, *rps)
+	t.Setenv("BINGWALLPAPER_RPS", "0.5")
+	if err := flag.Set("quiet", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if *rps != 0.5 {
+		t.Fatalf("-rps %v, want 0.5 from the environment", *rps)
+	}
+	// The test binary and other tests set flags of their own.
+	args := cronFlags()
+	if !slices.Contains(args, "-quiet=true") || slices.ContainsFunc(args, func(arg string) bool { return strings.HasPrefix(arg, "-rps=") }) {
+		t.Errorf("flags %q, want -quiet without -rps", args)
+	}
+}
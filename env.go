@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Prefix of environment variables mirroring flags, e.g. BINGWALLPAPER_NO_SET for -no-set.
+const envPrefix = "BINGWALLPAPER_"
+
+// Flags set from environment variables by applyEnv, to the name of the variable.
+var fromEnv = make(map[string]string)
+
+// Shorter names of variables, read if the variable named by envName isn't set.
+var envAliases = map[string]string{
+	"dir": "BINGWP_DIR",
+}
+
+// Name of the environment variable mirroring the flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Where the value of the explicitly set flag comes from, for config.
+func flagSource(name string) string {
+	if variable, ok := fromEnv[name]; ok {
+		return "$" + variable
+	}
+	return "flag -" + name
+}
+
+// Set flags which aren't given on the command line from their environment variables, so containers
+// can be configured without flags. Values are parsed like flag values.
+func applyEnv() error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		variable := envName(f.Name)
+		value, ok := os.LookupEnv(variable)
+		if alias, hasAlias := envAliases[f.Name]; !ok && hasAlias {
+			variable = alias
+			value, ok = os.LookupEnv(variable)
+		}
+		if !ok || explicit[f.Name] || err != nil {
+			return
+		}
+		if setErr := flag.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("Invalid $%s: %s", variable, setErr)
+			return
+		}
+		fromEnv[f.Name] = variable
+	})
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import "errors"
+
+// Kinds of failures, told apart with errors.Is. Errors of a kind are either wrapped with %w or, to
+// keep their messages, marked with withKind.
+var (
+	// Page or image which doesn't exist (yet). Dates failed with it are retried by the next runs.
+	errNotAvailable = errors.New("not available yet")
+	// Request which failed on the network or with an unexpected status. Retried by the next runs.
+	errFetch = errors.New("request failed")
+	// Page which doesn't have the expected markup. Retrying doesn't help, the selectors need fixing.
+	errParse = errors.New("unexpected markup")
+	// Wallpapers file which can't be read or written, or image which can't be saved, e.g. on a full
+	// disk. Runs stop on it, every further date would fail the same way.
+	errStore = errors.New("store failed")
+
+	// Image smaller than -min-width or -min-height, discarded with -discard-small.
+	errTooSmall = errors.New("smaller than the minimum dimensions")
+	// Image larger than -max-image-size.
+	errTooLarge = errors.New("larger than the maximum image size")
+
+	// Results of checkImage.
+	errMissing = errors.New("missing")
+	errCorrupt = errors.New("corrupt")
+)
+
+// Error marked with a kind. The message is the error's own, errors.Is matches both the kind and the
+// errors it wraps, and errors.As finds the underlying types.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// Mark the error with the kind. Nil stays nil.
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind, err}
+}
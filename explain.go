@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+)
+
+// Credentials in urls: passwords of the user info and values of query parameters named like keys.
+var (
+	secretUserInfo = regexp.MustCompile(`(://[^/\s:@]+):[^/\s@]+@`)
+	secretParam    = regexp.MustCompile(`(?i)([?&](?:token|access_token|key|api_key|apikey|sig|signature|secret|password|auth)=)[^&\s"]+`)
+)
+
+// Replace credentials in the text with REDACTED, so that traces can be posted in bug reports.
+func redact(text string) string {
+	text = secretUserInfo.ReplaceAllString(text, "$1:REDACTED@")
+	return secretParam.ReplaceAllString(text, "${1}REDACTED")
+}
+
+// Print a step of the run for -explain.
+func explain(format string, args ...any) {
+	if *explainRun {
+		log.Print("explain: " + redact(fmt.Sprintf(format, args...)))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Colors of -fallback-color, a second one making a vertical gradient.
+var fallbackColors []color.RGBA
+
+func initFallbackColor() error {
+	if *fallbackColor == "" {
+		return nil
+	}
+	parts := strings.Split(*fallbackColor, ",")
+	if len(parts) > 2 {
+		return fmt.Errorf("Malformed -fallback-color %q, expected a color or two separated by a comma", *fallbackColor)
+	}
+	for _, part := range parts {
+		c, err := parseHexColor(strings.TrimSpace(part))
+		if err != nil {
+			return fmt.Errorf("Malformed -fallback-color: %s", err)
+		}
+		fallbackColors = append(fallbackColors, c)
+	}
+	return nil
+}
+
+// With -fallback-color, set an image of the color if the run ends with nothing stored, e.g. on the
+// first boot without network, so the desktop is in a known state. Errors are only logged, as this
+// runs deferred.
+func setColorFallback(st *store) {
+	if len(fallbackColors) == 0 || *noSet {
+		return
+	}
+	if newest, err := st.newest(); err != nil || !newest.IsZero() {
+		return
+	}
+	if isHeadless() && !wallpaperSetter.noDisplay {
+		return
+	}
+	filename, err := colorImage()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	log.Print(msg("fallback-color", *fallbackColor))
+	if _, err = applyWallpaper(wallpaper{filename: filename}); err != nil {
+		log.Print(err)
+	}
+}
+
+// Generate an image of fallbackColors of the size of -screen, 1920 pixels wide if only its shape is
+// known, and save it as PNG into <img-dir>/.fallback. Returns its name relative to imgDir.
+func colorImage() (string, error) {
+	screen, err := parseScreen(*screenArg)
+	if err != nil {
+		log.Print(err)
+		screen = screenShape{width: 1920, height: 1080, exact: true}
+	}
+	width, height := screen.width, screen.height
+	if !screen.exact {
+		width, height = 1920, 1920*screen.height/screen.width
+	}
+	hex := strings.ReplaceAll(strings.ReplaceAll(*fallbackColor, "#", ""), ",", "-")
+	name := fmt.Sprintf(".fallback/%s-%dx%d.png", strings.ToLower(hex), width, height)
+	if _, err = os.Stat(filepath.Join(imgDir, name)); err == nil {
+		return name, nil
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	top, bottom := fallbackColors[0], fallbackColors[len(fallbackColors)-1]
+	for y := range height {
+		c := color.RGBA{
+			uint8((int(top.R)*(height-1-y) + int(bottom.R)*y) / max(height-1, 1)),
+			uint8((int(top.G)*(height-1-y) + int(bottom.G)*y) / max(height-1, 1)),
+			uint8((int(top.B)*(height-1-y) + int(bottom.B)*y) / max(height-1, 1)),
+			255,
+		}
+		for x := range width {
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	var b bytes.Buffer
+	if err = png.Encode(&b, img); err != nil {
+		return "", fmt.Errorf("Could not encode the fallback image: %s", err)
+	}
+	if err = os.MkdirAll(filepath.Join(imgDir, ".fallback"), 0755); err != nil {
+		return "", err
+	}
+	if err = writeFileAtomic(filepath.Join(imgDir, name), b.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("Could not write %s: %s", name, err)
+	}
+	return name, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Download and log wallpapers of the inclusive date range, walking older listing pages as needed.
+// The wallpaper isn't set.
+func fetch(args []string) error {
+	flags := flag.NewFlagSet("fetch", flag.ExitOnError)
+	sinceStr := flags.String("since", "", "first date, YYYYMMDD (required)")
+	untilStr := flags.String("until", today.Format(localDateLayout), "last date, YYYYMMDD")
+	force := flags.Bool("force", false, "download dates which are stored already")
+	flags.Parse(args)
+
+	if *sinceStr == "" {
+		return fmt.Errorf("fetch: -since is required")
+	}
+	since, err := time.Parse(localDateLayout, *sinceStr)
+	if err != nil {
+		return fmt.Errorf("fetch: malformed -since: %s", err)
+	}
+	until, err := time.Parse(localDateLayout, *untilStr)
+	if err != nil {
+		return fmt.Errorf("fetch: malformed -until: %s", err)
+	}
+	if until.Before(since) {
+		return fmt.Errorf("fetch: -until is before -since")
+	}
+
+	if err = ensureImgDir(); err != nil {
+		return err
+	}
+	st := &store{path: wpFile}
+	if err = st.migrate(); err != nil {
+		return err
+	}
+	listed, err := listLinks(since, until)
+	if err != nil {
+		return err
+	}
+	links := make([]link, 0, len(listed))
+	for _, l := range listed {
+		if !*force {
+			e, stored, err := st.get(l.date)
+			if err != nil {
+				return err
+			}
+			if stored {
+				if err = checkImage(e); err == nil {
+					continue
+				}
+				log.Print(msg("repairing", err))
+			}
+		}
+		links = append(links, l)
+	}
+
+	failures := 0
+	for i := len(links) - 1; i >= 0; i-- {
+		if runCtx.Err() != nil {
+			log.Print(msg("deadline", *deadline, len(links)-1-i-failures, failures))
+			return fmt.Errorf("fetch: %d of %d dates left after the deadline", i+1, len(links))
+		}
+		wp, err := downloadWallpaper(links[i].url)
+		if errors.Is(err, errStore) {
+			return err
+		}
+		if err != nil {
+			log.Println(err)
+			failures++
+			continue
+		}
+		if err = logWallpaper(wp); err != nil {
+			return err
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("fetch: %d of %d dates failed", failures, len(links))
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Messages of the script by locale and key. Only the script's own messages are translated; the
+// wallpaper title and description are shown as they are, and errors stay in English, so that they
+// can be searched for in bug reports. A new locale needs every key of "en".
+var messages = map[string]map[string]string{
+	"en": {
+		"usage":               "Usage: %s [flags] [command [command flags]]",
+		"usage-default":       "Without command, download new wallpapers and set the newest one.",
+		"usage-commands":      "Commands:",
+		"usage-flags":         "Flags:",
+		"no-display-set":      "No display session, %s is not set as the wallpaper",
+		"no-display-message":  "No display session, the message is not shown",
+		"not-available":       "Wallpaper at %s: %s",
+		"no-older":            "The source has no wallpapers before %s",
+		"page-not-saved":      "Could not save page %s: %s",
+		"too-small":           "%s is %dx%d, smaller than -min-width/-min-height, it is not set as the wallpaper",
+		"too-small-discarded": "Discarded wallpaper, it is retried by the next run: %s",
+		"trash-emptied":       "Removed %d files from %s",
+		"removals-total":      "%d files, %.1f MiB",
+		"repairing":           "Downloading again: %s",
+		"jitter":              "Waiting %s before the run",
+		"set-skipped":         "Skipping %s: %s",
+		"set-retry":           "Setting the wallpaper failed, retrying: %s",
+		"previous-unchecked":  "Could not check the %s started by the previous run (pid %d), it is left running: %s",
+		"store-migrated":      "Upgraded %s from format %d to %d, the old file is saved as %s",
+		"fetch-failed":        "Wallpaper at %s will be retried, the site failed: %s",
+		"newest-failed":       "Wallpaper at %s will be retried: %s",
+		"notify-failed":       "Could not show the description: %s",
+		"listing-failed":      "The listing will be retried, the site failed: %s",
+		"listing-loop":        "Listing page %s was read already, the listing ends here",
+		"listing-stuck":       "Listing page %s has no dates before %s, the listing ends here",
+		"summary":             "%d dates due, %d files written, %.1f MiB downloaded, %d failed, wallpaper set: %s, took %s",
+		"summary-copied":      ", %d copied to %s",
+		"yes":                 "yes",
+		"no":                  "no",
+		"deadline":            "Deadline of %s passed, stopping: %d downloaded, %d failed",
+		"mirror":              "%s, trying the mirror %s",
+		"set-deferred":        "%s is set by the first run within -set-window %s",
+		"store-damaged":       "%s; run verify -repair to rewrite it and import <img-dir> to log images whose records were lost",
+		"parse-retry":         "%s; fetching the pages again",
+		"parse-cached":        "Pages of %s failed to parse, using the ones saved by an earlier run",
+		"monitors-changed":    "Monitors changed, setting %s again",
+		"workspace-focused":   "Workspace %d focused, setting %s",
+		"no-workspaces":       "-per-workspace needs i3, Sway or Hyprland, the newest wallpaper stays on all workspaces",
+		"fallback":            "Setting %s until the newest wallpaper can be downloaded",
+		"collage":             "%d wallpapers tiled into %s",
+		"reparsed":            "%d of %d wallpapers changed",
+		"fallback-color":      "No wallpaper is available, setting %s",
+		"init-not-set":        "First run, %s is downloaded but not set as the wallpaper (-no-set-on-init)",
+		"stale":               "The newest wallpaper is from %s, older than %s; the source or the script may be broken",
+	},
+	"de": {
+		"usage":               "Aufruf: %s [Optionen] [Befehl [Befehlsoptionen]]",
+		"usage-default":       "Ohne Befehl werden neue Hintergrundbilder heruntergeladen und das neueste wird gesetzt.",
+		"usage-commands":      "Befehle:",
+		"usage-flags":         "Optionen:",
+		"no-display-set":      "Keine Display-Sitzung, %s wird nicht als Hintergrundbild gesetzt",
+		"no-display-message":  "Keine Display-Sitzung, die Nachricht wird nicht angezeigt",
+		"not-available":       "Hintergrundbild vom %s: %s",
+		"no-older":            "Die Quelle hat keine Hintergrundbilder vor dem %s",
+		"page-not-saved":      "Seite %s konnte nicht gespeichert werden: %s",
+		"too-small":           "%s ist %dx%d, kleiner als -min-width/-min-height, es wird nicht als Hintergrundbild gesetzt",
+		"too-small-discarded": "Hintergrundbild verworfen, der nächste Lauf versucht es erneut: %s",
+		"trash-emptied":       "%d Dateien aus %s entfernt",
+		"removals-total":      "%d Dateien, %.1f MiB",
+		"repairing":           "Wird erneut heruntergeladen: %s",
+		"jitter":              "Warte %s vor dem Lauf",
+		"set-skipped":         "%s wird übersprungen: %s",
+		"set-retry":           "Setzen des Hintergrundbilds fehlgeschlagen, neuer Versuch: %s",
+		"previous-unchecked":  "%s vom vorigen Lauf (PID %d) konnte nicht geprüft werden und läuft weiter: %s",
+		"store-migrated":      "%s von Format %d auf %d aktualisiert, die alte Datei ist als %s gesichert",
+		"fetch-failed":        "Hintergrundbild vom %s wird erneut versucht, die Seite ist fehlgeschlagen: %s",
+		"newest-failed":       "Hintergrundbild vom %s wird erneut versucht: %s",
+		"notify-failed":       "Die Beschreibung konnte nicht angezeigt werden: %s",
+		"listing-failed":      "Die Liste wird erneut versucht, die Seite ist fehlgeschlagen: %s",
+		"listing-loop":        "Listenseite %s wurde bereits gelesen, die Liste endet hier",
+		"listing-stuck":       "Listenseite %s hat keine Tage vor dem %s, die Liste endet hier",
+		"summary":             "%d Tage fällig, %d Dateien geschrieben, %.1f MiB heruntergeladen, %d fehlgeschlagen, Hintergrund gesetzt: %s, Dauer %s",
+		"summary-copied":      ", %d nach %s kopiert",
+		"yes":                 "ja",
+		"no":                  "nein",
+		"deadline":            "Frist von %s abgelaufen, Abbruch: %d heruntergeladen, %d fehlgeschlagen",
+		"mirror":              "%s, versuche den Spiegel %s",
+		"set-deferred":        "%s wird vom ersten Lauf innerhalb von -set-window %s gesetzt",
+		"store-damaged":       "%s; verify -repair schreibt die Datei neu, import <img-dir> erfasst Bilder mit verlorenen Einträgen",
+		"parse-retry":         "%s; die Seiten werden erneut abgerufen",
+		"parse-cached":        "Seiten von %s konnten nicht verarbeitet werden, die von einem früheren Lauf gespeicherten werden verwendet",
+		"monitors-changed":    "Monitore geändert, %s wird erneut gesetzt",
+		"workspace-focused":   "Arbeitsfläche %d fokussiert, %s wird gesetzt",
+		"no-workspaces":       "-per-workspace braucht i3, Sway oder Hyprland, das neueste Hintergrundbild bleibt auf allen Arbeitsflächen",
+		"fallback":            "%s wird gesetzt, bis das neueste Hintergrundbild heruntergeladen werden kann",
+		"collage":             "%d Hintergrundbilder in %s zusammengestellt",
+		"reparsed":            "%d von %d Hintergrundbildern geändert",
+		"fallback-color":      "Kein Hintergrundbild verfügbar, %s wird gesetzt",
+		"init-not-set":        "Erster Lauf, %s wird heruntergeladen, aber nicht als Hintergrundbild gesetzt (-no-set-on-init)",
+		"stale":               "Das neueste Hintergrundbild ist vom %s, älter als %s; die Quelle oder das Skript ist womöglich defekt",
+	},
+}
+
+// Locale of the messages: -lang, otherwise the language of $LC_ALL, $LC_MESSAGES or $LANG, falling
+// back to English for unknown languages.
+func locale() string {
+	lang := *langArg
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if lang != "" {
+			break
+		}
+		lang = os.Getenv(name)
+	}
+	// de_DE.UTF-8 -> de
+	if i := strings.IndexAny(lang, "_.@-"); i >= 0 {
+		lang = lang[:i]
+	}
+	lang = strings.ToLower(lang)
+	if _, ok := messages[lang]; ok {
+		return lang
+	}
+	return "en"
+}
+
+// Message by key in the current locale, formatted with args.
+func msg(key string, args ...any) string {
+	format, ok := messages[locale()][key]
+	if !ok {
+		format = messages["en"][key]
+	}
+	return fmt.Sprintf(format, args...)
+}
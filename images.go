@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// Extensions of the image types. Saved files get them instead of the extensions from urls, which
+// may be missing or misleading.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+	"image/bmp":  ".bmp",
+}
+
+// Extension of the image detected from its first bytes, or from the Content-Type header value if
+// the bytes aren't recognized. Empty if neither is a known image type.
+func detectExtension(head []byte, contentType string) string {
+	if ext := imageExtensions[http.DetectContentType(head)]; ext != "" {
+		return ext
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return imageExtensions[mediaType]
+}
+
+// Replace the extension of the filename with ext, unless ext is empty.
+func withExtension(filename, ext string) string {
+	if ext == "" {
+		return filename
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ext
+}
+
+// Dimensions of the image file.
+func imageSize(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Could not read dimensions of %s: %s", path, err)
+	}
+	return config.Width, config.Height, nil
+}
+
+// Characters which aren't allowed in file names on some file systems.
+var nameReplacer = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
+
+// Name of the file to save the wallpaper into, from -name-template and the original name with the
+// detected extension. A file of another date with the same name isn't overwritten, a counter is
+// appended instead.
+func imageName(wp wallpaper, orig string) (string, error) {
+	ext := filepath.Ext(orig)
+	name := strings.NewReplacer(
+		"{date}", wp.date.Format(localDateLayout),
+		"{title}", wp.title,
+		"{orig}", strings.TrimSuffix(orig, ext),
+	).Replace(*nameTemplate)
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' {
+			return -1
+		}
+		return r
+	}, nameReplacer.Replace(name))
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return "", fmt.Errorf("-name-template %q gives an empty name for %s", *nameTemplate, wp.src)
+	}
+
+	e, stored, err := (&store{path: wpFile}).get(wp.date)
+	if err != nil {
+		return "", err
+	}
+	for i := 1; ; i++ {
+		filename := name + ext
+		if i > 1 {
+			filename = fmt.Sprintf("%s-%d%s", name, i, ext)
+		}
+		if ok, _ := sink.exists(filename); !ok || (stored && e.filename == filename) {
+			return filename, nil
+		}
+	}
+}
+
+// SHA-256 checksum of the file, hex-encoded.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("Could not read %s: %s", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Check that the file of the entry exists, is a decodable image and, if the entry has a checksum,
+// matches it. Returns the problem found, wrapping errMissing or errCorrupt.
+func checkImage(e entry) error {
+	path := filepath.Join(imgDir, e.filename)
+	date := e.date.Format(localDateLayout)
+	if ok, _ := sink.exists(e.filename); !ok {
+		return fmt.Errorf("%s: %s is %w", date, e.filename, errMissing)
+	}
+	if _, _, err := imageSize(path); err != nil {
+		return fmt.Errorf("%s: %s is %w: %s", date, e.filename, errCorrupt, err)
+	}
+	if e.sha256 == "" {
+		return nil
+	}
+	sum, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+	if sum != e.sha256 {
+		return fmt.Errorf("%s: %s is %w: doesn't match its checksum", date, e.filename, errCorrupt)
+	}
+	return nil
+}
+
+// Crop the image centered to the shape of the screen and save the crop as JPEG into
+// <img-dir>/.cropped, keeping the original intact. Returns the crop's name relative to imgDir, or
+// the filename itself if the image already has the shape or is smaller than the screen.
+func cropToScreen(filename string, screen screenShape) (string, error) {
+	path := filepath.Join(imgDir, filename)
+	width, height, err := imageSize(path)
+	if err != nil {
+		return filename, err
+	}
+	if screen.exact && (width < screen.width || height < screen.height) {
+		return filename, nil
+	}
+	cropWidth, cropHeight := width, width*screen.height/screen.width
+	if cropHeight > height {
+		cropWidth, cropHeight = height*screen.width/screen.height, height
+	}
+	// Differences of a few pixels are rounding.
+	if width-cropWidth < 4 && height-cropHeight < 4 {
+		return filename, nil
+	}
+	base := filepath.Base(filename)
+	name := fmt.Sprintf(".cropped/%s-%dx%d.jpg", strings.TrimSuffix(base, filepath.Ext(base)), cropWidth, cropHeight)
+	if _, err = os.Stat(filepath.Join(imgDir, name)); err == nil {
+		return name, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return filename, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return filename, fmt.Errorf("Could not decode %s: %s", path, err)
+	}
+	x, y := (width-cropWidth)/2, (height-cropHeight)/2
+	bounds := image.Rect(x, y, x+cropWidth, y+cropHeight).Add(img.Bounds().Min)
+	cropped := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}).SubImage(bounds)
+
+	var b bytes.Buffer
+	if err = jpeg.Encode(&b, cropped, &jpeg.Options{Quality: 95}); err != nil {
+		return filename, fmt.Errorf("Could not encode the crop of %s: %s", path, err)
+	}
+	if err = os.MkdirAll(filepath.Join(imgDir, ".cropped"), 0755); err != nil {
+		return filename, err
+	}
+	if err = writeFileAtomic(filepath.Join(imgDir, name), b.Bytes(), 0644); err != nil {
+		return filename, fmt.Errorf("Could not write %s: %s", name, err)
+	}
+	return name, nil
+}
+
+// Place the image centered over a blurred, scaled-up copy of itself filling the shape of the screen,
+// e.g. for portrait images on wide screens, and save the result as JPEG into <img-dir>/.filled.
+// Returns its name relative to imgDir, or the filename itself if the image already has the shape.
+func fillToScreen(filename string, screen screenShape) (string, error) {
+	path := filepath.Join(imgDir, filename)
+	width, height, err := imageSize(path)
+	if err != nil {
+		return filename, err
+	}
+	// Without the screen size the canvas is as large as the image along its longer side.
+	canvasWidth, canvasHeight := screen.width, screen.height
+	if !screen.exact {
+		canvasWidth, canvasHeight = width, width*screen.height/screen.width
+		if canvasHeight < height {
+			canvasWidth, canvasHeight = height*screen.width/screen.height, height
+		}
+	}
+	if width*canvasHeight == height*canvasWidth {
+		return filename, nil
+	}
+	base := filepath.Base(filename)
+	name := fmt.Sprintf(".filled/%s-%dx%d.jpg", strings.TrimSuffix(base, filepath.Ext(base)), canvasWidth, canvasHeight)
+	if _, err = os.Stat(filepath.Join(imgDir, name)); err == nil {
+		return name, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return filename, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return filename, fmt.Errorf("Could not decode %s: %s", path, err)
+	}
+
+	// The background is blurred at a small size, which is faster and smoother once scaled up.
+	const shrink = 16
+	small := image.NewRGBA(image.Rect(0, 0, max(canvasWidth/shrink, 1), max(canvasHeight/shrink, 1)))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, coverRect(img.Bounds(), small.Bounds()), draw.Src, nil)
+	blur(small, 3)
+	canvas := image.NewRGBA(image.Rect(0, 0, canvasWidth, canvasHeight))
+	draw.BiLinear.Scale(canvas, canvas.Bounds(), small, small.Bounds(), draw.Src, nil)
+
+	// The image itself fits the canvas.
+	fitWidth, fitHeight := canvasWidth, height*canvasWidth/width
+	if fitHeight > canvasHeight {
+		fitWidth, fitHeight = width*canvasHeight/height, canvasHeight
+	}
+	x, y := (canvasWidth-fitWidth)/2, (canvasHeight-fitHeight)/2
+	draw.CatmullRom.Scale(canvas, image.Rect(x, y, x+fitWidth, y+fitHeight), img, img.Bounds(), draw.Src, nil)
+
+	var b bytes.Buffer
+	if err = jpeg.Encode(&b, canvas, &jpeg.Options{Quality: 95}); err != nil {
+		return filename, fmt.Errorf("Could not encode the filled %s: %s", path, err)
+	}
+	if err = os.MkdirAll(filepath.Join(imgDir, ".filled"), 0755); err != nil {
+		return filename, err
+	}
+	if err = writeFileAtomic(filepath.Join(imgDir, name), b.Bytes(), 0644); err != nil {
+		return filename, fmt.Errorf("Could not write %s: %s", name, err)
+	}
+	return name, nil
+}
+
+// Centered part of the source with the shape of the target, so that scaled to the target it covers
+// it completely.
+func coverRect(source, target image.Rectangle) image.Rectangle {
+	w, h := source.Dx(), source.Dy()
+	coverWidth, coverHeight := w, w*target.Dy()/target.Dx()
+	if coverHeight > h {
+		coverWidth, coverHeight = h*target.Dx()/target.Dy(), h
+	}
+	x, y := source.Min.X+(w-coverWidth)/2, source.Min.Y+(h-coverHeight)/2
+	return image.Rect(x, y, x+coverWidth, y+coverHeight)
+}
+
+// Blur the image in place. Three passes of a box blur approximate a Gaussian blur.
+func blur(img *image.RGBA, radius int) {
+	b := img.Bounds()
+	tmp := make([]uint8, len(img.Pix))
+	for pass := 0; pass < 3; pass++ {
+		boxBlur(img.Pix, tmp, b.Dx(), b.Dy(), img.Stride, 4, radius)
+		boxBlur(tmp, img.Pix, b.Dy(), b.Dx(), 4, img.Stride, radius)
+	}
+}
+
+// Average each pixel of the lines of src with radius neighbours along the line into dst. Lines and
+// pixels are addressed by steps in bytes, so the same code blurs rows and columns.
+func boxBlur(src, dst []uint8, length, lines, lineStep, pixelStep, radius int) {
+	for line := 0; line < lines; line++ {
+		start := line * lineStep
+		for c := 0; c < 4; c++ {
+			for i := 0; i < length; i++ {
+				sum, n := 0, 0
+				for j := max(i-radius, 0); j <= min(i+radius, length-1); j++ {
+					sum += int(src[start+j*pixelStep+c])
+					n++
+				}
+				dst[start+i*pixelStep+c] = uint8(sum / n)
+			}
+		}
+	}
+}
+
+// Whether the dimensions satisfy -min-width and -min-height. Unknown (zero) dimensions do.
+func bigEnough(width, height int) bool {
+	if width == 0 && height == 0 {
+		return true
+	}
+	return width >= *minWidth && height >= *minHeight
+}
+
+// Create imgDir/YYYYMMDD<ext> pointing to the wallpaper file, replacing the previous one. It is a
+// relative symlink, so the directory can be moved, or a copy on Windows, where symlinks need
+// privileges.
+func linkByDate(date string, filename string) error {
+	alias := filepath.Join(imgDir, date+filepath.Ext(filename))
+	if alias == filepath.Join(imgDir, filename) {
+		return nil
+	}
+	if err := os.Remove(alias); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Could not replace %s: %s", alias, err)
+	}
+	if runtime.GOOS == "windows" {
+		return copyFile(filepath.Join(imgDir, filename), alias)
+	}
+	if err := os.Symlink(filename, alias); err != nil {
+		return fmt.Errorf("Could not create %s: %s", alias, err)
+	}
+	return nil
+}
+
+// Place the wallpaper file into -copy-to, as a hard link with -link. Files which are there already
+// are kept, the directory is the user's.
+func copyToDir(filename string) error {
+	if err := os.MkdirAll(*copyTo, 0755); err != nil {
+		return fmt.Errorf("Could not create %s: %s", *copyTo, err)
+	}
+	src, dst := filepath.Join(imgDir, filename), filepath.Join(*copyTo, filename)
+	if _, err := os.Lstat(dst); err == nil {
+		return nil
+	}
+	var err error
+	if *linkCopy {
+		err = os.Link(src, dst)
+	} else {
+		err = copyFile(src, dst)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not copy %s to %s: %s", filename, *copyTo, err)
+	}
+	stats.copied++
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Convert the WebP image to JPEG for setters which can't display WebP. The JPEG is saved next to it
+// with the .jpg extension, the original is removed unless -keep-original. Returns the name of the
+// file to use, which is unchanged if the image isn't WebP; the format is detected from the bytes.
+func convertWebP(filename string) (string, error) {
+	path := filepath.Join(imgDir, filename)
+	in, err := os.Open(path)
+	if err != nil {
+		return filename, err
+	}
+	defer in.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(in, head)
+	if http.DetectContentType(head[:n]) != "image/webp" {
+		return filename, nil
+	}
+	if _, err = in.Seek(0, io.SeekStart); err != nil {
+		return filename, err
+	}
+	img, err := webp.Decode(in)
+	if err != nil {
+		return filename, fmt.Errorf("Could not decode %s: %s", path, err)
+	}
+
+	jpegName := withExtension(filename, ".jpg")
+	var b bytes.Buffer
+	if err = jpeg.Encode(&b, img, &jpeg.Options{Quality: 95}); err != nil {
+		return filename, fmt.Errorf("Could not encode %s as JPEG: %s", path, err)
+	}
+	if err = writeFileAtomic(filepath.Join(imgDir, jpegName), b.Bytes(), 0644); err != nil {
+		return filename, fmt.Errorf("Could not write %s: %s", jpegName, err)
+	}
+	if !*keepOrig {
+		if err = removeImage(filename); err != nil {
+			return jpegName, err
+		}
+	}
+	return jpegName, nil
+}
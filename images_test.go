@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckImage(t *testing.T) {
+	dir := useTempDir(t)
+	image := testJPEG(t, 64, 36)
+	sum := sha256.Sum256(image)
+	checksum := hex.EncodeToString(sum[:])
+	for name, data := range map[string][]byte{
+		"valid.jpg":     image,
+		"truncated.jpg": image[:20],
+		"garbage.jpg":   []byte("<html>not an image</html>"),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		name string
+		e    entry
+		want error
+	}{
+		{"present and valid", entry{filename: "valid.jpg", sha256: checksum}, nil},
+		{"present without checksum", entry{filename: "valid.jpg"}, nil},
+		{"present with another checksum", entry{filename: "valid.jpg", sha256: hex.EncodeToString(make([]byte, 32))}, errCorrupt},
+		{"present and truncated", entry{filename: "truncated.jpg", sha256: checksum}, errCorrupt},
+		{"present and not an image", entry{filename: "garbage.jpg"}, errCorrupt},
+		{"missing", entry{filename: "missing.jpg", sha256: checksum}, errMissing},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.e.date = mustParseDate(t, "20240101")
+			err := checkImage(test.e)
+			if test.want == nil && err != nil || test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("error %v, want %v", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Date in the name of an image, e.g. 20240105, 2024-01-05 or 2024_01_05.
+var nameDate = regexp.MustCompile(`(\d{4})[-_]?(\d{2})[-_]?(\d{2})`)
+
+// Date of the image inferred from its name, false if the name has none.
+func dateOfName(name string) (time.Time, bool) {
+	for _, m := range nameDate.FindAllStringSubmatch(name, -1) {
+		date, err := time.Parse(localDateLayout, m[1]+m[2]+m[3])
+		if err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Description of the image from the first line of its sidecar file <name>.txt, if any.
+func sidecarDescription(path string) string {
+	f, err := os.Open(strings.TrimSuffix(path, filepath.Ext(path)) + ".txt")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// Add images of an existing collection to the store. Dates are taken from the file names; dates
+// which are stored already keep their entries. Images outside imgDir are copied into it.
+func importImages(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only print what would be imported")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: bingwallpaper import [-dry-run] DIR")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	dir := expandPath(flags.Arg(0))
+
+	st := &store{path: wpFile}
+	entries, err := st.entries()
+	if err != nil {
+		return err
+	}
+	stored := make(map[time.Time]bool, len(entries))
+	for _, e := range entries {
+		stored[e.date] = true
+	}
+	images := make(map[string]bool, len(imageExtensions))
+	for _, ext := range imageExtensions {
+		images[ext] = true
+	}
+	images[".jpeg"] = true
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("Could not read %s: %s", dir, err)
+	}
+	inImgDir := sameDir(dir, imgDir)
+	var found []entry
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || f.Type()&os.ModeSymlink != 0 || strings.HasPrefix(name, ".") || !images[strings.ToLower(filepath.Ext(name))] {
+			continue
+		}
+		date, ok := dateOfName(name)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Skipping %s: no date in the name\n", name)
+			continue
+		}
+		if stored[date] {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %s is stored already\n", name, date.Format(localDateLayout))
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if !inImgDir {
+			if _, err := os.Stat(filepath.Join(imgDir, name)); err == nil {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %s exists\n", name, filepath.Join(imgDir, name))
+				continue
+			}
+		}
+		// Unknown fields are left blank.
+		e := entry{date: date, filename: name, description: sidecarDescription(path)}
+		if e.width, e.height, err = imageSize(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %s\n", name, err)
+			continue
+		}
+		if e.sha256, err = fileChecksum(path); err != nil {
+			return err
+		}
+		e.format = strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+		if e.format == "jpeg" {
+			e.format = "jpg"
+		}
+		stored[date] = true
+		found = append(found, e)
+		fmt.Println(date.Format(localDateLayout), name)
+	}
+	if *dryRun || len(found) == 0 {
+		return nil
+	}
+
+	if !inImgDir {
+		if err = ensureImgDir(); err != nil {
+			return err
+		}
+		for _, e := range found {
+			if err = copyFile(filepath.Join(dir, e.filename), filepath.Join(imgDir, e.filename)); err != nil {
+				return fmt.Errorf("Could not copy %s: %s", e.filename, err)
+			}
+		}
+	}
+	// Entries added by a concurrent run in the meantime win.
+	return st.update(func(entries []entry) []entry {
+		have := make(map[time.Time]bool, len(entries))
+		for _, e := range entries {
+			have[e.date] = true
+		}
+		for _, e := range found {
+			if !have[e.date] {
+				entries = append(entries, e)
+			}
+		}
+		return entries
+	})
+}
+
+// Whether both paths are the same directory.
+func sameDir(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Formats of the store's lines selected by -store-format.
+var storeFormats = []string{"text", "jsonl"}
+
+func checkStoreFormat(format string) error {
+	for _, f := range storeFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown store format %q, expected one of %v", format, storeFormats)
+}
+
+func parseJSONEntry(line string) (entry, error) {
+	var j jsonEntry
+	if err := json.Unmarshal([]byte(line), &j); err != nil {
+		return entry{}, fmt.Errorf("malformed line %q: %s", line, err)
+	}
+	date, err := time.Parse(localDateLayout, j.Date)
+	if err != nil {
+		return entry{}, fmt.Errorf("malformed date %q", j.Date)
+	}
+	if j.Filename == "" {
+		return entry{}, fmt.Errorf("malformed line %q: no filename", line)
+	}
+	return entry{
+		date:        date,
+		filename:    j.Filename,
+		description: j.Description,
+		page:        j.Page,
+		image:       j.Image,
+		mirror:      j.Mirror,
+		bingID:      j.BingID,
+		location:    j.Location,
+		width:       j.Width,
+		height:      j.Height,
+		sha256:      j.SHA256,
+		format:      j.Format,
+		author:      j.Author,
+		bytes:       j.Bytes,
+	}, nil
+}
+
+func formatJSONEntry(e entry) string {
+	data, _ := json.Marshal(e.toJSON())
+	return string(data)
+}
+
+// Write the entries of the store into a new file in another format. The store itself is left
+// alone; use the new file with -wp-file and -store-format.
+func convertStore(args []string) error {
+	flags := flag.NewFlagSet("convert-store", flag.ExitOnError)
+	to := flags.String("to", "jsonl", "format of the new file: text or jsonl")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: bingwallpaper convert-store [-to FORMAT] FILE")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err := checkStoreFormat(*to); err != nil {
+		return err
+	}
+
+	entries, err := (&store{path: wpFile}).entries()
+	if err != nil {
+		return err
+	}
+	out := &store{path: expandPath(flags.Arg(0)), format: *to}
+	if _, err = os.Stat(out.path); err == nil {
+		return fmt.Errorf("convert-store: %s exists", out.path)
+	}
+	if err = out.write(entries); err != nil {
+		return err
+	}
+	// The new file is in the current format, so it isn't migrated.
+	return writeFileAtomic(out.versionFile(), []byte(strconv.Itoa(storeVersion)+"\n"), 0644)
+}
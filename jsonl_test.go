@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStoreFormatsRoundTrip(t *testing.T) {
+	for _, format := range storeFormats {
+		t.Run(format, func(t *testing.T) {
+			useTempDir(t)
+			st := &store{path: wpFile, format: format}
+			want := sampleEntries(t)
+			if err := st.write(want); err != nil {
+				t.Fatal(err)
+			}
+			got, err := st.entries()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("entries read back\n%+v\nwant\n%+v", got, want)
+			}
+			// Without gaps the last date is the newest entry.
+			lastDate, err := readLastDate(st)
+			if err != nil || !lastDate.Equal(want[0].date) {
+				t.Errorf("last date %s, %v, want %s", lastDate.Format(localDateLayout), err, want[0].date.Format(localDateLayout))
+			}
+		})
+	}
+}
+
+func TestConvertStore(t *testing.T) {
+	dir := useTempDir(t)
+	want := sampleEntries(t)
+	if err := (&store{path: wpFile, format: "text"}).write(want); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "wallpapers.jsonl")
+	if err := convertStore([]string{"-to", "jsonl", out}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := (&store{path: out, format: "jsonl"}).entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("converted entries\n%+v\nwant\n%+v", got, want)
+	}
+	// An existing file isn't overwritten.
+	if err = convertStore([]string{"-to", "jsonl", out}); err == nil {
+		t.Error("existing file overwritten")
+	}
+}
+
+func TestCheckStoreFormat(t *testing.T) {
+	for format, valid := range map[string]bool{"text": true, "jsonl": true, "sqlite": false, "": false} {
+		if err := checkStoreFormat(format); (err == nil) != valid {
+			t.Errorf("checkStoreFormat(%q) = %v, want valid %v", format, err, valid)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Entry as printed by commands with -json, and as a line of a jsonl store.
+type jsonEntry struct {
+	Date        string `json:"date"`
+	Filename    string `json:"filename"`
+	Description string `json:"description"`
+	Page        string `json:"page,omitempty"`
+	Image       string `json:"image,omitempty"`
+	Mirror      string `json:"mirror,omitempty"`
+	BingID      string `json:"bing_id,omitempty"`
+	Location    string `json:"location,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	Format      string `json:"format,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Bytes       int64  `json:"bytes,omitempty"`
+}
+
+func (e entry) toJSON() jsonEntry {
+	return jsonEntry{
+		Date:        e.date.Format(localDateLayout),
+		Filename:    e.filename,
+		Description: e.description,
+		Page:        e.page,
+		Image:       e.image,
+		Mirror:      e.mirror,
+		BingID:      e.bingID,
+		Location:    e.location,
+		Width:       e.width,
+		Height:      e.height,
+		SHA256:      e.sha256,
+		Format:      e.format,
+		Author:      e.author,
+		Bytes:       e.bytes,
+	}
+}
+
+// Print stored entries from the newest to the oldest.
+func list(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	entries, err := (&store{path: wpFile}).entries()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		printed := make([]jsonEntry, len(entries))
+		for i, e := range entries {
+			printed[i] = e.toJSON()
+		}
+		return printJSON(printed)
+	}
+	for _, e := range entries {
+		fmt.Printf("%s %s %s\n", e.date.Format(localDateLayout), e.filename, e.description)
+		if e.page != "" {
+			fmt.Printf("         page: %s\n", e.page)
+		}
+		if e.image != "" {
+			fmt.Printf("         image: %s\n", e.image)
+		}
+		if e.width > 0 && e.height > 0 {
+			fmt.Printf("         size: %dx%d\n", e.width, e.height)
+		}
+		if e.location != "" {
+			fmt.Printf("         location: %s\n", e.location)
+		}
+		if e.bingID != "" {
+			fmt.Printf("         bing id: %s\n", e.bingID)
+		}
+		if e.bytes > 0 {
+			fmt.Printf("         downloaded: %.1f MiB\n", float64(e.bytes)/(1<<20))
+		}
+	}
+	return nil
+}
+
+// Print the value as indented JSON to stdout.
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Link to the page of the wallpaper at the date.
+type link struct {
+	date time.Time
+	url  string
+}
+
+// Fetch the listing page and parse links to wallpaper pages, from the newest to the oldest, and the
+// url of the next (older) listing page, which is empty on the last page.
+func readListing(url string) ([]link, string, error) {
+	root, err := fetchPage(url, "listing")
+	if err != nil {
+		return nil, "", err
+	}
+
+	thumbs := root.Find("ul.imglist > li")
+	if thumbs.Length() == 0 {
+		return nil, "", withKind(errParse, fmt.Errorf("Could not find thumbs on %s", url))
+	}
+	links := make([]link, 0, thumbs.Length())
+	for i := range thumbs.Nodes {
+		thumb := thumbs.Eq(i)
+		dateStr := thumb.Find("time").First().Text()
+		date, err := time.Parse(remoteDateLayout, dateStr)
+		if err != nil {
+			return nil, "", withKind(errParse, fmt.Errorf("Could not parse date on %s: %s", url, err))
+		}
+		href, ok := thumb.Find("a").First().Attr("href")
+		if !ok {
+			return nil, "", withKind(errParse, fmt.Errorf("Could not find url at date %s", date.Format(localDateLayout)))
+		}
+		if href, err = resolveURL(url, href); err != nil {
+			return nil, "", err
+		}
+		links = append(links, link{date: date, url: href})
+	}
+
+	next, err := nextPage(root, url)
+	if err != nil {
+		return nil, "", err
+	}
+	explain("listing %s: %d dates, next page %q", url, len(links), next)
+	return links, next, nil
+}
+
+// Element holding the link or the token of the next listing page, see nextPage. Without it only
+// the first page is read.
+var nextSelector selector
+
+func initPagination() error {
+	if strings.TrimSpace(*nextSelectorArg) == "" {
+		nextSelector = selector{}
+		return nil
+	}
+	s, err := parseSelector(*nextSelectorArg)
+	if err != nil {
+		return fmt.Errorf("Invalid -next-selector: %s", err)
+	}
+	nextSelector = s
+	return nil
+}
+
+// Url of the next (older) listing page, empty on the last page and without -next-selector. By
+// default the value matched by -next-selector is a link to it; with -page-param it is a token, e.g.
+// a page number or a cursor, put into that query parameter of the url of the current page.
+func nextPage(root *goquery.Document, url string) (string, error) {
+	if nextSelector.css == "" {
+		return "", nil
+	}
+	element := root.Find(nextSelector.css).First()
+	value, ok := element.Attr(nextSelector.attr)
+	if nextSelector.attr == "" {
+		value, ok = element.Text(), element.Length() > 0
+	}
+	value = strings.TrimSpace(value)
+	if !ok || value == "" {
+		return "", nil
+	}
+	if *pageParam == "" {
+		return resolveURL(url, value)
+	}
+	page, err := neturl.Parse(url)
+	if err != nil {
+		return "", withKind(errParse, fmt.Errorf("Malformed url %q: %s", url, err))
+	}
+	query := page.Query()
+	if query.Get(*pageParam) == value {
+		// The token of the current page, the listing ends here.
+		return "", nil
+	}
+	query.Set(*pageParam, value)
+	page.RawQuery = query.Encode()
+	return page.String(), nil
+}
+
+// Walk the listing pages from startURL, from the newest to the oldest, passing the links of each
+// page to the function until it returns false or the listing ends. Since a broken next link could
+// lead back to pages read already, the walk also ends at a url seen before and at a page adding no
+// date older than the previous one.
+func walkListing(page func(listing []link) bool) error {
+	visited := make(map[string]bool)
+	oldest := time.Time{}
+	for url := startURL; url != ""; {
+		if visited[url] {
+			log.Print(msg("listing-loop", url))
+			break
+		}
+		visited[url] = true
+		listing, next, err := readListing(url)
+		if err != nil {
+			return err
+		}
+		last := listing[len(listing)-1].date
+		if !oldest.IsZero() && !last.Before(oldest) {
+			log.Print(msg("listing-stuck", url, oldest.Format(localDateLayout)))
+			break
+		}
+		oldest = last
+		if !page(listing) {
+			break
+		}
+		url = next
+	}
+	return nil
+}
+
+// Links of the listing from the newest date down to the first date which isn't after the date,
+// walking older listing pages as long as all their dates are after it.
+func listingSince(date time.Time) ([]link, error) {
+	links := make([]link, 0)
+	err := walkListing(func(listing []link) bool {
+		links = append(links, listing...)
+		return listing[len(listing)-1].date.After(date)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// Links of the inclusive date range, from the newest to the oldest, walking older listing pages
+// until the oldest date is reached. Dates later than the near-future window are skipped.
+func listLinks(since, until time.Time) ([]link, error) {
+	links := make([]link, 0)
+	oldest := time.Time{}
+	err := walkListing(func(listing []link) bool {
+		for _, l := range listing {
+			oldest = l.date
+			if l.date.Before(since) || l.date.After(until) || l.date.After(today.AddDate(0, 0, *futureDays)) {
+				continue
+			}
+			links = append(links, l)
+		}
+		return oldest.After(since)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if since.Before(oldest) {
+		log.Print(msg("no-older", oldest.Format(localDateLayout)))
+	}
+	return links, nil
+}
+
+// Validators (ETag and Last-Modified) of the listing page, saved by the last run which left nothing
+// to do.
+func listingValidatorsFile() string {
+	return filepath.Join(imgDir, ".listing")
+}
+
+// Validators of the listing page got by listingChanged.
+var listingValidators string
+
+// Check with a cheap HEAD request whether the listing page changed since it was saved by
+// saveListingValidators. Pages without validators and failed requests count as changed, the
+// following GET request reports the error.
+func listingChanged() bool {
+	request, err := http.NewRequestWithContext(runCtx, http.MethodHead, startURL, nil)
+	if err != nil {
+		return true
+	}
+	explain("HEAD %s", startURL)
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return true
+	}
+	response.Body.Close()
+	etag, lastModified := response.Header.Get("ETag"), response.Header.Get("Last-Modified")
+	if response.StatusCode != http.StatusOK || (etag == "" && lastModified == "") {
+		return true
+	}
+	listingValidators = etag + "\n" + lastModified + "\n"
+	saved, err := os.ReadFile(listingValidatorsFile())
+	return err != nil || string(saved) != listingValidators
+}
+
+// Save validators got by listingChanged, so the next run exits early if the listing is the same.
+func saveListingValidators() error {
+	if listingValidators == "" {
+		return nil
+	}
+	return writeFileAtomic(listingValidatorsFile(), []byte(listingValidators), 0644)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Dates of the links, YYYYMMDD separated by spaces.
+func linkDates(links []link) string {
+	dates := make([]string, len(links))
+	for i, l := range links {
+		dates[i] = l.date.Format(localDateLayout)
+	}
+	return strings.Join(dates, " ")
+}
+
+func TestListLinksSkipsFutureDates(t *testing.T) {
+	newFakeSite(t, "20240106", "20240105", "20240104", "20240103")
+	setFlag(t, &today, mustParseDate(t, "20240104"))
+	for _, test := range []struct {
+		futureDays int
+		want       string
+	}{
+		{0, "20240104 20240103"},
+		{1, "20240105 20240104 20240103"},
+		{2, "20240106 20240105 20240104 20240103"},
+	} {
+		setFlag(t, futureDays, test.futureDays)
+		links, err := listLinks(mustParseDate(t, "20240103"), mustParseDate(t, "20240110"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := linkDates(links); got != test.want {
+			t.Errorf("-future-days %d: dates %s, want %s", test.futureDays, got, test.want)
+		}
+	}
+}
+
+func TestFutureDateNotAvailable(t *testing.T) {
+	useTempDir(t)
+	site := newFakeSite(t, "20240105", "20240104")
+	// Listed before it is published: the detail page or the image isn't there yet.
+	site.mux.HandleFunc("/detail/20240105.html", http.NotFound)
+	if _, err := downloadWallpaper(baseURL + "/day/20240105.html"); !errors.Is(err, errNotAvailable) {
+		t.Errorf("missing detail page: %v, want %v", err, errNotAvailable)
+	}
+	site.mux.HandleFunc("/img/20240104.jpg", http.NotFound)
+	if _, err := downloadWallpaper(baseURL + "/day/20240104.html"); !errors.Is(err, errNotAvailable) {
+		t.Errorf("missing image: %v, want %v", err, errNotAvailable)
+	}
+	if entries, _ := (&store{path: wpFile}).entries(); len(entries) != 0 {
+		t.Errorf("%d entries stored, want none", len(entries))
+	}
+}
+
+// Listing page of the dates, followed by the markup of the next page.
+func listingPage(t *testing.T, dates []string, next string) string {
+	var b strings.Builder
+	b.WriteString(`<html><body><ul class="imglist">`)
+	for _, date := range dates {
+		fmt.Fprintf(&b, `<li><a href="/day/%s.html"><time>%s</time></a></li>`, date, remoteDate(t, date))
+	}
+	fmt.Fprintf(&b, `</ul>%s</body></html>`, next)
+	return b.String()
+}
+
+// Serve the dates as a listing of two pages at startURL: the first page links the second through
+// the markup, the second has no next page.
+func twoPageListing(t *testing.T, site *fakeSite, first, second []string, next string) {
+	t.Helper()
+	site.listing = func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" || r.URL.Query().Get("cursor") == "older" {
+			fmt.Fprint(w, listingPage(t, second, ""))
+			return
+		}
+		fmt.Fprint(w, listingPage(t, first, next))
+	}
+	site.mux.HandleFunc("/list/new/desc/classic-2.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, listingPage(t, second, ""))
+	})
+}
+
+func TestListingSinceTwoPages(t *testing.T) {
+	first := []string{"20240110", "20240109", "20240108"}
+	second := []string{"20240107", "20240106", "20240105"}
+	for _, test := range []struct {
+		name         string
+		nextSelector string
+		pageParam    string
+		next         string
+	}{
+		{"link", "a[rel='next'][@href]", "", `<a rel="next" href="classic-2.html">Older</a>`},
+		{"page number", "a.older[@data-page]", "page", `<a class="older" data-page="2">Older</a>`},
+		{"cursor in the text", "span#cursor", "cursor", `<span id="cursor"> older </span>`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			site := newFakeSite(t)
+			twoPageListing(t, site, first, second, test.next)
+			setFlag(t, nextSelectorArg, test.nextSelector)
+			setFlag(t, pageParam, test.pageParam)
+			setFlag(t, &nextSelector, nextSelector)
+			if err := initPagination(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Dates of the first page only don't need the second.
+			links, err := listingSince(mustParseDate(t, "20240108"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := linkDates(links), "20240110 20240109 20240108"; got != want {
+				t.Errorf("since 20240108: %s, want %s", got, want)
+			}
+			links, err = listingSince(mustParseDate(t, "20240106"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := linkDates(links), "20240110 20240109 20240108 20240107 20240106 20240105"; got != want {
+				t.Errorf("since 20240106: %s, want %s", got, want)
+			}
+			// The listing ends with the second page.
+			links, err = listingSince(mustParseDate(t, "20231231"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(links) != len(first)+len(second) {
+				t.Errorf("since 20231231: %s, want both pages", linkDates(links))
+			}
+		})
+	}
+}
+
+// Next links leading back to pages read already end the listing instead of reading it forever.
+func TestListingSinceLoop(t *testing.T) {
+	first := []string{"20240110", "20240109", "20240108"}
+	second := []string{"20240107", "20240106", "20240105"}
+	third := []string{"20240104", "20240103"}
+	for _, test := range []struct {
+		name         string
+		nextSelector string
+		pageParam    string
+		// Serves the listing pages at startURL.
+		listing func(w http.ResponseWriter, r *http.Request)
+		want    []string
+		// Requests of startURL and of the second page, whatever the query.
+		wantRequests [2]int
+	}{
+		{"link back to the first page", "a[rel='next'][@href]", "", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, listingPage(t, first, `<a rel="next" href="classic-2.html">Older</a>`))
+		}, append(first, second...), [2]int{1, 1}},
+		{"cursors cycling", "span#cursor", "cursor", func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Query().Get("cursor") {
+			case "":
+				fmt.Fprint(w, listingPage(t, first, `<span id="cursor">b</span>`))
+			case "b":
+				fmt.Fprint(w, listingPage(t, second, `<span id="cursor">c</span>`))
+			default:
+				fmt.Fprint(w, listingPage(t, third, `<span id="cursor">b</span>`))
+			}
+		}, append(append(first, second...), third...), [2]int{3, 0}},
+		{"pages without older dates", "a.older[@data-page]", "page", func(w http.ResponseWriter, r *http.Request) {
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page == 0 {
+				fmt.Fprint(w, listingPage(t, first, `<a class="older" data-page="2">Older</a>`))
+				return
+			}
+			fmt.Fprint(w, listingPage(t, second, fmt.Sprintf(`<a class="older" data-page="%d">Older</a>`, page+1)))
+		}, append(first, second...), [2]int{3, 0}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			site := newFakeSite(t)
+			site.listing = test.listing
+			site.mux.HandleFunc("/list/new/desc/classic-2.html", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, listingPage(t, second, `<a rel="next" href="classic.html">Newer</a>`))
+			})
+			setFlag(t, nextSelectorArg, test.nextSelector)
+			setFlag(t, pageParam, test.pageParam)
+			setFlag(t, &nextSelector, nextSelector)
+			if err := initPagination(); err != nil {
+				t.Fatal(err)
+			}
+
+			links, err := listingSince(mustParseDate(t, "20231231"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := linkDates(links), strings.Join(test.want, " "); got != want {
+				t.Errorf("dates %s, want %s", got, want)
+			}
+			requests := [2]int{site.requests["/list/new/desc/classic.html"], site.requests["/list/new/desc/classic-2.html"]}
+			if requests != test.wantRequests {
+				t.Errorf("listing pages requested %v times, want %v", requests, test.wantRequests)
+			}
+		})
+	}
+}
+
+// Date ranges of fetch, url and verify -repair share the guard of the listing walk.
+func TestListLinksLoop(t *testing.T) {
+	site := newFakeSite(t)
+	site.listing = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, listingPage(t, []string{"20240110", "20240109"}, `<a rel="next" href="classic-2.html">Older</a>`))
+	}
+	site.mux.HandleFunc("/list/new/desc/classic-2.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, listingPage(t, []string{"20240108", "20240107"}, `<a rel="next" href="classic.html">Newer</a>`))
+	})
+	setFlag(t, &today, mustParseDate(t, "20240110"))
+	setFlag(t, nextSelectorArg, "a[rel='next'][@href]")
+	setFlag(t, &nextSelector, nextSelector)
+	if err := initPagination(); err != nil {
+		t.Fatal(err)
+	}
+	links, err := listLinks(mustParseDate(t, "20231231"), mustParseDate(t, "20240109"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := linkDates(links), "20240109 20240108 20240107"; got != want {
+		t.Errorf("dates %s, want %s", got, want)
+	}
+	if n := site.requests["/list/new/desc/classic.html"]; n != 1 {
+		t.Errorf("first page requested %d times, want once", n)
+	}
+}
+
+func TestNextPageDefault(t *testing.T) {
+	site := newFakeSite(t)
+	twoPageListing(t, site, []string{"20240110", "20240109"}, []string{"20240108", "20240107"}, `<a rel="next" href="classic-2.html">Older</a>`)
+	setFlag(t, nextSelectorArg, flag.Lookup("next-selector").DefValue)
+	setFlag(t, pageParam, "")
+	setFlag(t, &nextSelector, nextSelector)
+	if err := initPagination(); err != nil {
+		t.Fatal(err)
+	}
+	// The zero-config default reads the first page only, even if it links a next one.
+	links, err := listingSince(mustParseDate(t, "20231231"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := linkDates(links), "20240110 20240109"; got != want {
+		t.Errorf("dates %s, want %s", got, want)
+	}
+	if n := site.requests["/list/new/desc/classic-2.html"]; n != 0 {
+		t.Errorf("next page requested %d times, want none", n)
+	}
+}
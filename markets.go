@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// Market of Bing wallpapers: its code as used by Bing and the region it stands for.
+type market struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// Markets Bing publishes wallpapers for. Bing doesn't list them anywhere, so the list is maintained
+// by hand.
+var markets = []market{
+	{"de-DE", "Germany"},
+	{"en-AU", "Australia"},
+	{"en-CA", "Canada (English)"},
+	{"en-GB", "United Kingdom"},
+	{"en-IN", "India"},
+	{"en-US", "United States"},
+	{"es-ES", "Spain"},
+	{"fr-CA", "Canada (French)"},
+	{"fr-FR", "France"},
+	{"it-IT", "Italy"},
+	{"ja-JP", "Japan"},
+	{"pt-BR", "Brazil"},
+	{"zh-CN", "China"},
+}
+
+// Check that the market code is known. Empty code means the market of the source. Codes are
+// compared case-insensitively, as Bing does; codes differing in one letter or the separator are
+// suggested for typos.
+func checkMarket(code string) error {
+	if code == "" {
+		return nil
+	}
+	normalize := strings.NewReplacer("-", "", "_", "")
+	typed := strings.ToLower(normalize.Replace(code))
+	var similar []string
+	for _, m := range markets {
+		if strings.EqualFold(m.Code, code) {
+			return nil
+		}
+		known := strings.ToLower(normalize.Replace(m.Code))
+		if len(known) != len(typed) {
+			continue
+		}
+		diff := 0
+		for i := range known {
+			if known[i] != typed[i] {
+				diff++
+			}
+		}
+		if diff <= 1 {
+			similar = append(similar, m.Code)
+		}
+	}
+	if len(similar) > 0 {
+		return fmt.Errorf("Unknown market %q, did you mean %s? Run the markets command to list all", code, strings.Join(similar, " or "))
+	}
+	return fmt.Errorf("Unknown market %q, run the markets command to list known ones", code)
+}
+
+// Print known market codes.
+func listMarkets(args []string) error {
+	flags := flag.NewFlagSet("markets", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	if *asJSON {
+		return printJSON(markets)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, m := range markets {
+		fmt.Fprintf(w, "%s\t%s\n", m.Code, m.Name)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const lastSuccessMetric = "bingwallpaper_last_success_timestamp_seconds"
+
+// Counters of the current run.
+type runStats struct {
+	// Dates due to download.
+	dates      int
+	downloaded int
+	failures   int
+	// Bytes of images and of pages downloaded.
+	bytes     int64
+	pageBytes int64
+	// Files placed into -copy-to.
+	copied int
+	// Whether the wallpaper was set.
+	set bool
+	// Failures of the setter, counted in failures too.
+	setFailures int
+	// Whether the newest wallpaper was left for the next run because the site failed or -deadline
+	// passed.
+	incomplete bool
+	// Whether the listing couldn't be fetched, so nothing could be done.
+	listingFailed bool
+	success       bool
+	// Date of the newest stored wallpaper after the run, and whether it is older than -stale-after.
+	newest time.Time
+	stale  bool
+}
+
+// Exit codes of the regular run, for cron and monitoring. Configuration errors and other fatal
+// errors exit with exitFailed too, see check.
+const (
+	// Everything is done, also when there was nothing to do.
+	exitOK = 0
+	// Some dates failed or were left for the next run, the others are stored.
+	exitPartial = 1
+	// Nothing could be done, e.g. the listing couldn't be fetched or every due date failed.
+	exitFailed = 2
+	// All dates are stored, only setting the wallpaper failed.
+	exitSetter = 3
+	// The run succeeded, but the newest wallpaper is older than -stale-after.
+	exitStale = 4
+)
+
+// Exit code of the run, the most severe problem wins.
+func (s runStats) exitCode() int {
+	switch {
+	case s.listingFailed, s.dates > 0 && s.downloaded == 0 && s.failures > 0:
+		return exitFailed
+	case s.failures > s.setFailures || s.incomplete:
+		return exitPartial
+	case s.setFailures > 0:
+		return exitSetter
+	case s.stale:
+		return exitStale
+	}
+	return exitOK
+}
+
+// Write metrics in the Prometheus text format for the node_exporter textfile collector. The file
+// is replaced atomically, so the collector never sees it half-written.
+func writeMetrics(path string, s runStats) error {
+	now := time.Now().Unix()
+	lastSuccess := previousSuccess(path)
+	success := 0
+	if s.success {
+		lastSuccess = now
+		success = 1
+	}
+
+	var b strings.Builder
+	metric := func(name, help string, value int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+	}
+	metric("bingwallpaper_last_run_timestamp_seconds", "Unix time of the last run.", now)
+	metric(lastSuccessMetric, "Unix time of the last successful run.", lastSuccess)
+	metric("bingwallpaper_last_run_success", "Whether the last run succeeded.", int64(success))
+	metric("bingwallpaper_images_downloaded", "Number of images downloaded by the last run.", int64(s.downloaded))
+	metric("bingwallpaper_download_failures", "Number of failed downloads in the last run.", int64(s.failures))
+	metric("bingwallpaper_downloaded_bytes", "Number of image bytes downloaded by the last run.", s.bytes)
+	metric("bingwallpaper_downloaded_page_bytes", "Number of page bytes downloaded by the last run.", s.pageBytes)
+	if !s.newest.IsZero() {
+		metric("bingwallpaper_newest_wallpaper_timestamp_seconds", "Unix time of the date of the newest stored wallpaper.", s.newest.Unix())
+	}
+	stale := 0
+	if s.stale {
+		stale = 1
+	}
+	metric("bingwallpaper_stale", "Whether the newest stored wallpaper is older than -stale-after.", int64(stale))
+
+	if err := writeFileAtomic(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("Could not write metrics file: %s", err)
+	}
+	return nil
+}
+
+// Fetch the last success timestamp from the previous metrics file, so that a failed run doesn't
+// reset it. Returns 0 if it is unknown.
+func previousSuccess(path string) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == lastSuccessMetric {
+			value, err := strconv.ParseInt(fields[1], 10, 64)
+			if err == nil {
+				return value
+			}
+		}
+	}
+	return 0
+}
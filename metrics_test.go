@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		stats runStats
+		want  int
+	}{
+		{"nothing to do", runStats{}, exitOK},
+		{"all downloaded", runStats{dates: 3, downloaded: 3, set: true}, exitOK},
+		{"some dates failed", runStats{dates: 3, downloaded: 2, failures: 1}, exitPartial},
+		{"newest left for the next run", runStats{dates: 1, incomplete: true}, exitPartial},
+		{"listing failed", runStats{listingFailed: true, failures: 1}, exitFailed},
+		{"listing failed beats stale", runStats{listingFailed: true, stale: true}, exitFailed},
+		{"every date failed", runStats{dates: 3, failures: 3}, exitFailed},
+		{"newest not published yet", runStats{dates: 1}, exitOK},
+		{"setter only", runStats{dates: 1, downloaded: 1, failures: 1, setFailures: 1}, exitSetter},
+		{"setter and a date", runStats{dates: 2, downloaded: 1, failures: 2, setFailures: 1}, exitPartial},
+		{"stale", runStats{stale: true}, exitStale},
+		{"setter beats stale", runStats{failures: 1, setFailures: 1, stale: true}, exitSetter},
+	} {
+		if got := test.stats.exitCode(); got != test.want {
+			t.Errorf("%s: exit code %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+// Exit codes of runs whose newest wallpaper fails with the kinds of errors.
+func TestExitCodeOfNewest(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		image   http.HandlerFunc
+		want    int
+		wantErr error
+	}{
+		{"downloaded", nil, exitOK, nil},
+		{"not published yet", http.NotFound, exitOK, nil},
+		{"site failing", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}, exitPartial, nil},
+		{"too large", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(make([]byte, 2048))
+		}, exitPartial, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useTempDir(t)
+			setFlag(t, noSet, true)
+			setFlag(t, maxImageSize, 1024)
+			site := newFakeSite(t, "20240101")
+			if test.image != nil {
+				site.mux.HandleFunc("/img/20240101.jpg", test.image)
+			}
+			_, err := processNewest(link{date: mustParseDate(t, "20240101"), url: baseURL + "/day/20240101.html"})
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("error %v, want %v", err, test.wantErr)
+			}
+			if got := stats.exitCode(); got != test.want {
+				t.Errorf("exit code %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+// Changed markup stops the run, which exits with 2 like other fatal errors.
+func TestChangedMarkupStopsRun(t *testing.T) {
+	useTempDir(t)
+	setFlag(t, noSet, true)
+	setFlag(t, &parseRetryDelay, 0)
+	site := newFakeSite(t, "20240101")
+	site.mux.HandleFunc("/detail/20240101.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><div class="redesigned">Lake</div></body></html>`)
+	})
+	done, err := processNewest(link{date: mustParseDate(t, "20240101"), url: baseURL + "/day/20240101.html"})
+	if done || !errors.Is(err, errParse) {
+		t.Errorf("done %v, error %v, want %v", done, err, errParse)
+	}
+}
+
+// Configuration and fatal errors exit with exitFailed and a plain message, after the metrics are
+// written. The program runs in a child process, since it exits.
+func TestFatalErrorExit(t *testing.T) {
+	if args := os.Getenv("BW_TEST_MAIN_ARGS"); args != "" {
+		os.Args = append([]string{"bingwallpaper"}, strings.Split(args, "\n")...)
+		main()
+		return
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	metrics := filepath.Join(dir, "bingwallpaper.prom")
+	for _, test := range []struct {
+		name        string
+		args        []string
+		wantOutput  string
+		wantMetrics bool
+	}{
+		{"configuration", []string{"-next-selector", "[@href]"}, "Invalid -next-selector", false},
+		{"image directory", []string{"-no-set", "-metrics-file", metrics, "-dir", file}, "is not a directory", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestFatalErrorExit$")
+			cmd.Env = append(os.Environ(), "HOME="+dir, "BW_TEST_MAIN_ARGS="+strings.Join(test.args, "\n"))
+			output, err := cmd.CombinedOutput()
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitFailed {
+				t.Fatalf("error %v, want exit code %d:\n%s", err, exitFailed, output)
+			}
+			if !strings.Contains(string(output), test.wantOutput) || strings.Contains(string(output), "goroutine") {
+				t.Errorf("output %q, want a plain message saying %q", output, test.wantOutput)
+			}
+			if _, err := os.Stat(metrics); (err == nil) != test.wantMetrics {
+				t.Errorf("metrics written: %v, want %v", err == nil, test.wantMetrics)
+			}
+		})
+	}
+}
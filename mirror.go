@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Pattern of image urls which have an equivalent on the mirror, nil without a mirror.
+var mirrorPattern *regexp.Regexp
+
+// Compile -mirror-pattern; it is only useful together with -mirror-url.
+func initMirror() error {
+	if *mirrorPatternArg == "" && *mirrorURL == "" {
+		return nil
+	}
+	if *mirrorPatternArg == "" || *mirrorURL == "" {
+		return fmt.Errorf("-mirror-pattern and -mirror-url must be given together")
+	}
+	pattern, err := regexp.Compile(*mirrorPatternArg)
+	if err != nil {
+		return fmt.Errorf("Invalid -mirror-pattern: %s", err)
+	}
+	mirrorPattern = pattern
+	return nil
+}
+
+// Url of the image on the mirror: -mirror-url with $1, $2... replaced by the groups of
+// -mirror-pattern matching src. Reports false if there is no mirror or the pattern doesn't match.
+func mirrorOf(src string) (string, bool) {
+	if mirrorPattern == nil {
+		return "", false
+	}
+	match := mirrorPattern.FindStringSubmatchIndex(src)
+	if match == nil {
+		return "", false
+	}
+	return string(mirrorPattern.ExpandString(nil, *mirrorURL, src, match)), true
+}
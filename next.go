@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+)
+
+// Set a random stored wallpaper, e.g. from a keybinding. The script has no daemon, so it sets the
+// wallpaper directly and the next run sets the newest one again only when a new date appears.
+// -match and -location restrict it to wallpapers whose record or location matches.
+func next(args []string) error {
+	flags := flag.NewFlagSet("next", flag.ExitOnError)
+	match := flags.String("match", "", "only wallpapers whose title, description, author or location match this regular expression, case-insensitively")
+	location := flags.String("location", "", "only wallpapers whose location matches this regular expression, case-insensitively")
+	flags.Parse(args)
+
+	if *noSet {
+		return fmt.Errorf("next: there is no setter with -no-set")
+	}
+	filters := make([]func(entry) bool, 0, 2)
+	if *match != "" {
+		pattern, err := regexp.Compile("(?i)" + *match)
+		if err != nil {
+			return fmt.Errorf("next: invalid -match: %s", err)
+		}
+		filters = append(filters, func(e entry) bool {
+			return pattern.MatchString(e.description) || pattern.MatchString(e.author) || pattern.MatchString(e.location)
+		})
+	}
+	if *location != "" {
+		pattern, err := regexp.Compile("(?i)" + *location)
+		if err != nil {
+			return fmt.Errorf("next: invalid -location: %s", err)
+		}
+		filters = append(filters, func(e entry) bool {
+			return pattern.MatchString(e.location)
+		})
+	}
+
+	entries, err := (&store{path: wpFile}).entries()
+	if err != nil {
+		return err
+	}
+	// Images which are missing or corrupt are skipped, a failing setter would be worse.
+	rand.Shuffle(len(entries), func(i, j int) {
+		entries[i], entries[j] = entries[j], entries[i]
+	})
+entries:
+	for _, e := range entries {
+		for _, matches := range filters {
+			if !matches(e) {
+				continue entries
+			}
+		}
+		if checkImage(e) != nil {
+			continue
+		}
+		return setWallpaper(e.wallpaper())
+	}
+	if len(filters) > 0 {
+		return fmt.Errorf("next: no stored wallpaper matches")
+	}
+	return fmt.Errorf("next: no stored wallpaper to set")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// Open the detail page of the stored wallpaper at the date, the newest one by default, in the
+// browser. The url is printed if there is no program to open it.
+func openPage(args []string) error {
+	flags := flag.NewFlagSet("open", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: bingwallpaper open [YYYYMMDD]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	st := &store{path: wpFile}
+	var e entry
+	if flags.NArg() > 0 {
+		date, err := time.Parse(localDateLayout, flags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("open: malformed date: %s", err)
+		}
+		var ok bool
+		if e, ok, err = st.get(date); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("open: no wallpaper stored at %s", flags.Arg(0))
+		}
+	} else {
+		entries, err := st.entries()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf("open: no wallpaper stored yet")
+		}
+		e = entries[0]
+	}
+	if e.page == "" {
+		return fmt.Errorf("open: the url of %s isn't stored, it was downloaded by an older version", e.date.Format(localDateLayout))
+	}
+
+	var opener []string
+	switch runtime.GOOS {
+	case "darwin":
+		opener = []string{"open"}
+	case "windows":
+		opener = []string{"rundll32", "url.dll,FileProtocolHandler"}
+	default:
+		opener = []string{"xdg-open"}
+	}
+	if _, err := exec.LookPath(opener[0]); err != nil {
+		fmt.Println(e.page)
+		return nil
+	}
+	return exec.Command(opener[0], append(opener[1:], e.page)...).Start()
+}
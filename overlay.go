@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Corners the caption of -overlay-caption may be drawn into.
+var overlayPositions = []string{"bottom-right", "bottom-left", "top-right", "top-left"}
+
+func checkOverlay() error {
+	if !slices.Contains(overlayPositions, *overlayPosition) {
+		return fmt.Errorf("Unknown overlay position %q, expected one of: %s", *overlayPosition, strings.Join(overlayPositions, ", "))
+	}
+	if *overlaySize <= 0 || *overlaySize > 20 {
+		return fmt.Errorf("Invalid overlay size %g, expected a percentage of the image height above 0 up to 20", *overlaySize)
+	}
+	if *overlayOpacity <= 0 || *overlayOpacity > 1 {
+		return fmt.Errorf("Invalid overlay opacity %g, expected above 0 up to 1", *overlayOpacity)
+	}
+	return nil
+}
+
+// Draw the title of the wallpaper, and the date with -overlay-date, into a corner of the image and
+// save the result as JPEG into <img-dir>/.overlay, keeping the original intact. Returns its name
+// relative to imgDir, or the filename itself if there is no title.
+func overlayCaption(filename string, wp wallpaper) (string, error) {
+	if wp.title == "" {
+		return filename, nil
+	}
+	lines := []string{wp.title}
+	if *overlayDate != "" {
+		lines = append(lines, wp.date.Format(*overlayDate))
+	}
+	// The name changes with the caption and its settings, so that changed settings take effect.
+	sum := sha256.Sum256(fmt.Appendf(nil, "%q %s %g %g", lines, *overlayPosition, *overlaySize, *overlayOpacity))
+	base := filepath.Base(filename)
+	name := fmt.Sprintf(".overlay/%s-%s.jpg", strings.TrimSuffix(base, filepath.Ext(base)), hex.EncodeToString(sum[:4]))
+	if _, err := os.Stat(filepath.Join(imgDir, name)); err == nil {
+		return name, nil
+	}
+
+	path := filepath.Join(imgDir, filename)
+	f, err := os.Open(path)
+	if err != nil {
+		return filename, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return filename, fmt.Errorf("Could not decode %s: %s", path, err)
+	}
+	bounds := img.Bounds()
+	canvas := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(canvas, canvas.Bounds(), img, bounds.Min, draw.Src)
+
+	face, err := captionFace(*overlaySize * float64(canvas.Bounds().Dy()) / 100)
+	if err != nil {
+		return filename, err
+	}
+	defer face.Close()
+	drawCaption(canvas, canvas.Bounds(), face, lines, *overlayPosition, *overlayOpacity)
+
+	var b bytes.Buffer
+	if err = jpeg.Encode(&b, canvas, &jpeg.Options{Quality: 95}); err != nil {
+		return filename, fmt.Errorf("Could not encode the captioned %s: %s", path, err)
+	}
+	if err = os.MkdirAll(filepath.Join(imgDir, ".overlay"), 0755); err != nil {
+		return filename, err
+	}
+	if err = writeFileAtomic(filepath.Join(imgDir, name), b.Bytes(), 0644); err != nil {
+		return filename, fmt.Errorf("Could not write %s: %s", name, err)
+	}
+	return name, nil
+}
+
+// Face of the bundled Go font with the size in pixels, so that captions don't depend on the fonts
+// installed.
+func captionFace(size float64) (font.Face, error) {
+	otf, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse the caption font: %s", err)
+	}
+	face, err := opentype.NewFace(otf, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("Could not load the caption font: %s", err)
+	}
+	return face, nil
+}
+
+// Draw the lines in white with a faint shadow, which keeps them readable on light skies, into the
+// given corner of the rectangle. The margin to the edges is one line height.
+func drawCaption(canvas *image.RGBA, r image.Rectangle, face font.Face, lines []string, position string, opacity float64) {
+	metrics := face.Metrics()
+	lineHeight := metrics.Height.Ceil()
+	margin := lineHeight
+	alpha := uint8(opacity * 255)
+	text := image.NewUniform(color.NRGBA{255, 255, 255, alpha})
+	shadow := image.NewUniform(color.NRGBA{0, 0, 0, alpha / 2})
+	offset := max(lineHeight/16, 1)
+
+	top := r.Min.Y + margin
+	if strings.HasPrefix(position, "bottom") {
+		top = r.Max.Y - margin - len(lines)*lineHeight
+	}
+	d := &font.Drawer{Dst: canvas, Face: face}
+	for i, line := range lines {
+		left := r.Min.X + margin
+		if strings.HasSuffix(position, "right") {
+			left = r.Max.X - margin - d.MeasureString(line).Ceil()
+		}
+		baseline := top + i*lineHeight + metrics.Ascent.Ceil()
+		d.Src = shadow
+		d.Dot = fixed.P(left+offset, baseline+offset)
+		d.DrawString(line)
+		d.Src = text
+		d.Dot = fixed.P(left, baseline)
+		d.DrawString(line)
+	}
+}
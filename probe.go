@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Set a generated test image, or a stored wallpaper with -image, with the configured setter and
+// report the result and how long it took. Nothing is logged or downloaded.
+func probeSetter(args []string) error {
+	flags := flag.NewFlagSet("probe-setter", flag.ExitOnError)
+	imageArg := flags.String("image", "", "path or date of a stored wallpaper to set instead of a generated image")
+	flags.Parse(args)
+
+	if *noSet {
+		return fmt.Errorf("probe-setter: there is no setter with -no-set")
+	}
+	fmt.Printf("setter: %s, mode: %s, headless: %t\n", wallpaperSetter.name, *fitMode, isHeadless())
+	if isHeadless() && !wallpaperSetter.noDisplay {
+		return fmt.Errorf("probe-setter: no display session, %s can't set the wallpaper (see -headless)", wallpaperSetter.name)
+	}
+
+	var path string
+	if *imageArg != "" {
+		entries, err := (&store{path: wpFile}).entries()
+		if err != nil {
+			return err
+		}
+		e, err := resolveImage(*imageArg, entries)
+		if err != nil {
+			return fmt.Errorf("probe-setter: %s", err)
+		}
+		path = filepath.Join(imgDir, e.filename)
+	} else {
+		dir, err := os.MkdirTemp("", "bingwallpaper-probe-")
+		if err != nil {
+			return fmt.Errorf("Could not create temporary directory: %s", err)
+		}
+		defer os.RemoveAll(dir)
+		path = filepath.Join(dir, "probe.png")
+		if err = writeProbeImage(path); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	err := wallpaperSetter.set(path, *fitMode)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		return fmt.Errorf("probe-setter: failed after %s: %s", elapsed, err)
+	}
+	fmt.Printf("set %s in %s\n", path, elapsed)
+	return nil
+}
+
+// Write a gradient which is easy to recognize on the desktop.
+func writeProbeImage(path string) error {
+	const width, height = 640, 360
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(255 * x / width), uint8(255 * y / height), 160, 255})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Could not create %s: %s", path, err)
+	}
+	if err = png.Encode(f, img); err != nil {
+		f.Close()
+		return fmt.Errorf("Could not write %s: %s", path, err)
+	}
+	return f.Close()
+}
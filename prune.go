@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Image files in imgDir which no store entry refers to, e.g. left by failed downloads or copied in
+// by hand. Files sharing the base name of a stored image, like a WebP original kept next to its
+// JPEG, and date links to stored images are kept.
+func orphans() ([]string, error) {
+	entries, err := (&store{path: wpFile}).entries()
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]bool, len(entries))
+	bases := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		stored[e.filename] = true
+		bases[strings.TrimSuffix(e.filename, filepath.Ext(e.filename))] = true
+	}
+	images := make(map[string]bool, len(imageExtensions))
+	for _, ext := range imageExtensions {
+		images[ext] = true
+	}
+	images[".jpeg"] = true
+
+	files, err := os.ReadDir(imgDir)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read %s: %s", imgDir, err)
+	}
+	var found []string
+	for _, f := range files {
+		name := f.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if f.IsDir() || strings.HasPrefix(name, ".") || !images[ext] || stored[name] {
+			continue
+		}
+		if f.Type()&os.ModeSymlink != 0 {
+			if target, err := os.Readlink(filepath.Join(imgDir, name)); err == nil && stored[filepath.Base(target)] {
+				continue
+			}
+		} else if bases[strings.TrimSuffix(name, filepath.Ext(name))] {
+			continue
+		}
+		found = append(found, name)
+	}
+	return found, nil
+}
+
+// List image files no store entry refers to, with their dates and sizes, and, with -delete, remove
+// them.
+func pruneOrphans(args []string) error {
+	flags := flag.NewFlagSet("prune-orphans", flag.ExitOnError)
+	remove := flags.Bool("delete", false, "remove the files, into the trash directory unless -hard-delete")
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	found, err := orphans()
+	if err != nil {
+		return err
+	}
+	list, err := listRemovals(imgDir, found)
+	if err != nil {
+		return err
+	}
+	if err = printRemovals(list, *asJSON); err != nil {
+		return err
+	}
+	if *remove {
+		for _, name := range found {
+			if err = removeImage(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// Transport waiting for the shared limiter before every request, so that page fetches and image
+// downloads together stay below -rps.
+type rateLimitedTransport struct {
+	limiter *rate.Limiter
+	base    http.RoundTripper
+}
+
+func (t rateLimitedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(request.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(request)
+}
+
+// Limit requests of httpClient to rps per second; 0 means no limit.
+func limitRequests(rps float64) {
+	if rps <= 0 {
+		return
+	}
+	httpClient.Transport = rateLimitedTransport{limiter: rate.NewLimiter(rate.Limit(rps), 1), base: http.DefaultTransport}
+}
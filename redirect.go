@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Follow at most limit redirects per request; 0 follows none. Each redirect is printed with
+// -explain, and a redirect back to a url already visited fails at once.
+// Failed redirects fail the request like a network error, see getResponse.
+func limitRedirects(limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("Invalid -max-redirects %d, expected 0 or more", limit)
+	}
+	httpClient.CheckRedirect = func(request *http.Request, via []*http.Request) error {
+		from := via[len(via)-1].URL.String()
+		explain("%s redirects to %s", from, request.URL)
+		if len(via) > limit {
+			return fmt.Errorf("more than %d redirects from %s", limit, via[0].URL)
+		}
+		for _, r := range via {
+			if r.URL.String() == request.URL.String() {
+				return fmt.Errorf("redirect loop from %s back to %s", from, request.URL)
+			}
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestLimitRedirects(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		limit int
+		path  string
+		want  error
+	}{
+		{"no redirect", 0, "/hop/0", nil},
+		{"chain within the limit", 3, "/hop/3", nil},
+		{"chain over the limit", 2, "/hop/3", errFetch},
+		{"none allowed", 0, "/hop/1", errFetch},
+		{"loop", 10, "/loop/a", errFetch},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			site := newFakeSite(t)
+			// /hop/<n> redirects n times before the image.
+			site.mux.HandleFunc("/hop/{n}", func(w http.ResponseWriter, r *http.Request) {
+				n, _ := strconv.Atoi(r.PathValue("n"))
+				if n == 0 {
+					w.Write(site.image)
+					return
+				}
+				http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+			})
+			site.mux.HandleFunc("/loop/a", func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/loop/b", http.StatusMovedPermanently)
+			})
+			site.mux.HandleFunc("/loop/b", func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/loop/a", http.StatusMovedPermanently)
+			})
+			if err := limitRedirects(test.limit); err != nil {
+				t.Fatal(err)
+			}
+
+			response, err := getResponse(baseURL + test.path)
+			if err == nil {
+				response.Body.Close()
+			}
+			if test.want == nil && err != nil || test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("error %v, want %v", err, test.want)
+			}
+			// A loop fails before the url is requested again rather than after the limit.
+			if test.name == "loop" && site.requests["/loop/a"] != 1 {
+				t.Errorf("/loop/a requested %d times, want once", site.requests["/loop/a"])
+			}
+		})
+	}
+}
+
+func TestLimitRedirectsNegative(t *testing.T) {
+	setFlag(t, &httpClient, &http.Client{})
+	if err := limitRedirects(-1); err == nil {
+		t.Error("negative limit accepted")
+	}
+}
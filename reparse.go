@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Parse the title, description, author and location of stored wallpapers again, from their pages
+// or, for entries without a page like imported ones, from their sidecar files, so that fixes of the
+// parsing apply to the archive. Images are never downloaded.
+func reparse(args []string) error {
+	flags := flag.NewFlagSet("reparse", flag.ExitOnError)
+	all := flags.Bool("all", false, "reparse every stored wallpaper")
+	dryRun := flags.Bool("dry-run", false, "only print what would change")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: bingwallpaper reparse [-dry-run] -all | YYYYMMDD...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *all == (flags.NArg() > 0) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	st := &store{path: wpFile}
+	entries, err := st.entries()
+	if err != nil {
+		return err
+	}
+	if !*all {
+		stored := make(map[time.Time]entry, len(entries))
+		for _, e := range entries {
+			stored[e.date] = e
+		}
+		entries = entries[:0]
+		for _, arg := range flags.Args() {
+			date, err := time.Parse(localDateLayout, arg)
+			if err != nil {
+				return fmt.Errorf("reparse: malformed date: %s", err)
+			}
+			e, ok := stored[date]
+			if !ok {
+				return fmt.Errorf("reparse: no wallpaper stored at %s", arg)
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	changed := make(map[time.Time]entry)
+	for _, e := range entries {
+		updated, err := reparseEntry(e)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		before, after := e.wallpaper(), updated.wallpaper()
+		var changes []string
+		for _, field := range [][3]string{
+			{"title", before.title, after.title},
+			{"description", before.description, after.description},
+			{"author", before.author, after.author},
+			{"location", before.location, after.location},
+		} {
+			if field[1] != field[2] {
+				changes = append(changes, fmt.Sprintf("         %s: %q -> %q", field[0], field[1], field[2]))
+			}
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		fmt.Println(e.date.Format(localDateLayout), e.filename)
+		fmt.Println(strings.Join(changes, "\n"))
+		changed[e.date] = updated
+	}
+	fmt.Println(msg("reparsed", len(changed), len(entries)))
+	if *dryRun || len(changed) == 0 {
+		return nil
+	}
+	// Other fields may have been changed by a concurrent run in the meantime.
+	return st.update(func(entries []entry) []entry {
+		for i, e := range entries {
+			if u, ok := changed[e.date]; ok && e.filename == u.filename {
+				entries[i].description, entries[i].author, entries[i].location = u.description, u.author, u.location
+			}
+		}
+		return entries
+	})
+}
+
+// Entry with the fields parsed again from its page, or from its sidecar file if it has no page.
+func reparseEntry(e entry) (entry, error) {
+	date := e.date.Format(localDateLayout)
+	if e.page == "" {
+		description := sidecarDescription(filepath.Join(imgDir, e.filename))
+		if description == "" {
+			return e, fmt.Errorf("%s: %s has neither a page nor a sidecar file", date, e.filename)
+		}
+		e.description = description
+		wp := e.wallpaper()
+		e.location = findLocation(wp.title, wp.description)
+		return e, nil
+	}
+	root, err := fetchPage(e.page, "detail")
+	if err != nil {
+		return e, fmt.Errorf("%s: %s", date, err)
+	}
+	wp, err := parseDetail(root, e.page)
+	if err != nil {
+		return e, fmt.Errorf("%s: %s", date, err)
+	}
+	if !wp.date.Equal(e.date) {
+		return e, fmt.Errorf("%s: %s is the page of %s", date, e.page, wp.date.Format(localDateLayout))
+	}
+	e.description = wp.title + ".  " + wp.description
+	e.author, e.location = wp.author, wp.location
+	return e, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Shape the wallpaper is fitted to. Width and height are pixels if exact, otherwise only their
+// ratio matters.
+type screenShape struct {
+	width, height int
+	exact         bool
+}
+
+// Parse -screen: auto for the size of the current screen, WxH for a size in pixels or W:H for an
+// aspect ratio.
+func parseScreen(value string) (screenShape, error) {
+	if value == "auto" {
+		return detectScreen()
+	}
+	var s screenShape
+	separator := ":"
+	if strings.Contains(value, "x") {
+		separator, s.exact = "x", true
+	}
+	w, h, _ := strings.Cut(value, separator)
+	var err1, err2 error
+	s.width, err1 = strconv.Atoi(w)
+	s.height, err2 = strconv.Atoi(h)
+	if err1 != nil || err2 != nil || s.width <= 0 || s.height <= 0 {
+		return s, fmt.Errorf("Malformed -screen %q, expected auto, WxH or W:H", value)
+	}
+	return s, nil
+}
+
+// Size of the focused or first screen, asked from the compositor on Wayland or from xrandr on X11.
+func detectScreen() (screenShape, error) {
+	var monitors []struct {
+		Width       int  `json:"width"`
+		Height      int  `json:"height"`
+		Focused     bool `json:"focused"`
+		Active      bool `json:"active"`
+		CurrentMode struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"current_mode"`
+	}
+	switch {
+	case os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != "":
+		output, err := exec.Command("hyprctl", "monitors", "-j").Output()
+		if err != nil {
+			return screenShape{}, fmt.Errorf("hyprctl monitors failed: %s", err)
+		}
+		if err = json.Unmarshal(output, &monitors); err != nil {
+			return screenShape{}, fmt.Errorf("Could not parse hyprctl monitors: %s", err)
+		}
+		for _, m := range monitors {
+			if m.Focused || len(monitors) == 1 {
+				return screenShape{m.Width, m.Height, true}, nil
+			}
+		}
+	case os.Getenv("SWAYSOCK") != "":
+		output, err := exec.Command("swaymsg", "-t", "get_outputs").Output()
+		if err != nil {
+			return screenShape{}, fmt.Errorf("swaymsg -t get_outputs failed: %s", err)
+		}
+		if err = json.Unmarshal(output, &monitors); err != nil {
+			return screenShape{}, fmt.Errorf("Could not parse swaymsg outputs: %s", err)
+		}
+		for _, m := range monitors {
+			if m.Active {
+				return screenShape{m.CurrentMode.Width, m.CurrentMode.Height, true}, nil
+			}
+		}
+	default:
+		output, err := exec.Command("xrandr", "--current").Output()
+		if err != nil {
+			return screenShape{}, fmt.Errorf("xrandr failed: %s", err)
+		}
+		// The current mode of a screen is marked with an asterisk.
+		if m := xrandrMode.FindSubmatch(output); m != nil {
+			w, _ := strconv.Atoi(string(m[1]))
+			h, _ := strconv.Atoi(string(m[2]))
+			return screenShape{w, h, true}, nil
+		}
+	}
+	return screenShape{}, fmt.Errorf("Could not detect the screen size, give -screen WxH")
+}
+
+var xrandrMode = regexp.MustCompile(`(?m)^\s+(\d+)x(\d+)\S*\s.*\*`)
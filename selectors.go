@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Selector of a value on a page: the attribute of the first matching element, or its text if attr
+// is empty.
+type selector struct {
+	css  string
+	attr string
+}
+
+func (s selector) String() string {
+	if s.attr == "" {
+		return s.css
+	}
+	return fmt.Sprintf("%s[@%s]", s.css, s.attr)
+}
+
+// Selectors of the values which the script can't work without. The first one matches the current
+// markup, the others are fallbacks surviving minor markup changes.
+var (
+	transitionalLinkSelectors = []selector{
+		{"a.fl", "href"},
+		{"a[href*='/detail/']", "href"},
+	}
+	dateSelectors = []selector{
+		{"div.detail time[itemprop='date']", ""},
+		{"time[itemprop='date']", ""},
+		{"div.detail time", ""},
+	}
+	imageSelectors = []selector{
+		{"#bing_wallpaper", "src"},
+		{"div.detail img", "src"},
+		{"meta[property='og:image']", "content"},
+	}
+	// Links to a larger image than the one shown, from -image-selectors, tried before
+	// imageSelectors.
+	largeImageSelectors []selector
+)
+
+// Parse a selector as it is printed: a CSS selector, followed by [@attr] if the value is the
+// attribute rather than the text of the element.
+func parseSelector(value string) (selector, error) {
+	css, attr := strings.TrimSpace(value), ""
+	if i := strings.LastIndex(css, "[@"); i >= 0 && strings.HasSuffix(css, "]") {
+		css, attr = css[:i], css[i+2:len(css)-1]
+	}
+	if css == "" {
+		return selector{}, fmt.Errorf("empty CSS selector in %q, expected e.g. a.download[@href]", value)
+	}
+	return selector{css, attr}, nil
+}
+
+// Parse -image-selectors, separated by semicolons, since CSS selectors contain commas.
+func initImageSelectors() error {
+	largeImageSelectors = nil
+	for _, value := range strings.Split(*imageSelectorsArg, ";") {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		s, err := parseSelector(value)
+		if err != nil {
+			return fmt.Errorf("Invalid -image-selectors: %s", err)
+		}
+		largeImageSelectors = append(largeImageSelectors, s)
+	}
+	return nil
+}
+
+// Phrase of -soft-404 found in the title or the main heading of the page, which the site serves
+// with status 200 instead of 404. Empty if the page looks real.
+func softNotFound(root *goquery.Document) string {
+	heading := strings.ToLower(root.Find("title").First().Text() + "\n" + root.Find("h1").First().Text())
+	for _, phrase := range strings.Split(*soft404, ",") {
+		phrase = strings.TrimSpace(phrase)
+		if phrase != "" && strings.Contains(heading, strings.ToLower(phrase)) {
+			return phrase
+		}
+	}
+	return ""
+}
+
+// Formats of images offered by the detail page, as extensions without the dot. The main image comes
+// first, followed by alternatives from <picture> sources and the other image selectors.
+func imageOffers(root *goquery.Document, page, main string) map[string]string {
+	offers := map[string]string{urlFormat(main): main}
+	add := func(src, format string) {
+		src = strings.TrimSpace(src)
+		if src == "" {
+			return
+		}
+		src, err := resolveURL(page, src)
+		if err != nil {
+			return
+		}
+		if format == "" {
+			format = urlFormat(src)
+		}
+		if _, ok := offers[format]; !ok && format != "" {
+			offers[format] = src
+		}
+	}
+	root.Find("picture source[srcset]").Each(func(_ int, source *goquery.Selection) {
+		srcset, _ := source.Attr("srcset")
+		// The first candidate is enough, the others differ in resolution only.
+		src, _, _ := strings.Cut(strings.TrimSpace(srcset), ",")
+		src, _, _ = strings.Cut(strings.TrimSpace(src), " ")
+		mediaType, _ := source.Attr("type")
+		add(src, strings.TrimPrefix(imageExtensions[mediaType], "."))
+	})
+	for _, s := range slices.Concat(largeImageSelectors, imageSelectors) {
+		value, _ := root.Find(s.css).First().Attr(s.attr)
+		add(value, "")
+	}
+	return offers
+}
+
+// Format of the image at the url judging by its extension, e.g. jpg.
+func urlFormat(url string) string {
+	url, _, _ = strings.Cut(url, "?")
+	format := strings.ToLower(strings.TrimPrefix(path.Ext(url), "."))
+	if format == "jpeg" {
+		return "jpg"
+	}
+	return format
+}
+
+// Find the value of the field using the first selector which matches a non-empty value.
+func findValue(root *goquery.Document, url, field string, selectors []selector) (string, error) {
+	for _, s := range selectors {
+		element := root.Find(s.css).First()
+		var value string
+		if s.attr == "" {
+			value = element.Text()
+		} else {
+			value, _ = element.Attr(s.attr)
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			explain("%s on %s: selector %s matched %q", field, url, s, value)
+			return value, nil
+		}
+	}
+	tried := make([]string, len(selectors))
+	for i, s := range selectors {
+		tried[i] = s.String()
+	}
+	hint := ""
+	if *saveHTMLDir == "" {
+		hint = " (run with -save-html to save the page)"
+	}
+	return "", withKind(errParse, fmt.Errorf("Could not find %s on %s, tried selectors: %s%s", field, url, strings.Join(tried, ", "), hint))
+}
+
+// Bing image id in an image url, e.g. OHR.Hallstatt_EN-US1234567890 in
+// .../th?id=OHR.Hallstatt_EN-US1234567890_1920x1080.jpg: the name of the image followed by the
+// market and a number. The OHR. prefix is often dropped by mirrors.
+var bingIDPattern = regexp.MustCompile(`(?:OHR\.)?[A-Za-z0-9]+_[A-Za-z]{2}-[A-Za-z]{2}\d+`)
+
+// Bing id of the image at the url, empty if the url has none.
+func bingID(src string) string {
+	id := bingIDPattern.FindString(src)
+	if id != "" && !strings.HasPrefix(id, "OHR.") {
+		id = "OHR." + id
+	}
+	return id
+}
+
+// Pattern of -location-pattern, nil if it is empty.
+var locationPattern *regexp.Regexp
+
+func initLocation() error {
+	locationPattern = nil
+	if *locationPatternArg == "" {
+		return nil
+	}
+	pattern, err := regexp.Compile(*locationPatternArg)
+	if err != nil {
+		return fmt.Errorf("Invalid -location-pattern: %s", err)
+	}
+	locationPattern = pattern
+	return nil
+}
+
+// Location of the photo found by -location-pattern in the title or else in the description: the
+// first group of the match, or the whole match if the pattern has no groups. Empty if neither
+// matches.
+func findLocation(texts ...string) string {
+	if locationPattern == nil {
+		return ""
+	}
+	for _, text := range texts {
+		if m := locationPattern.FindStringSubmatch(text); m != nil {
+			return strings.TrimSpace(m[len(m)-1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Page of the markup.
+func testDocument(t *testing.T, html string) *goquery.Document {
+	t.Helper()
+	root, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// Page the site serves with status 200 for dates it doesn't have.
+const soft404Page = `<html><head><title>Page Not Found - Bing Wallpaper</title></head>
+<body><h1>Oops!</h1><p>The wallpaper you are looking for doesn't exist.</p></body></html>`
+
+func TestSoftNotFound(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		phrases string
+		html    string
+		want    string
+	}{
+		{"title", "404,not found", soft404Page, "not found"},
+		{"heading", "404,not found", `<html><body><h1>Error 404</h1></body></html>`, "404"},
+		{"real page", "404,not found", `<html><head><title>Wallpaper</title></head><body><h1>Lake</h1></body></html>`, ""},
+		{"phrase in the text only", "not found", `<html><body><h1>Lake</h1><p>not found anywhere else</p></body></html>`, ""},
+		{"configured phrase", "oops", soft404Page, "oops"},
+		{"no phrases", "", soft404Page, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			setFlag(t, soft404, test.phrases)
+			if got := softNotFound(testDocument(t, test.html)); got != test.want {
+				t.Errorf("softNotFound = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSoft404DetailPageNotAvailable(t *testing.T) {
+	useTempDir(t)
+	site := newFakeSite(t, "20240102", "20240101")
+	site.mux.HandleFunc("/detail/20240102.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, soft404Page)
+	})
+	_, err := downloadWallpaper(baseURL + "/day/20240102.html")
+	if !errors.Is(err, errNotAvailable) {
+		t.Errorf("error %v, want %v", err, errNotAvailable)
+	}
+}
+
+// Detail page offering a UHD download next to the image shown.
+const uhdDetailPage = `<html><body><div class="detail">
+<time itemprop="date">Jan 2, 2024</time>
+<div class="title">Lake © Photographer</div>
+<div class="description">A lake in the mountains</div>
+<img id="bing_wallpaper" src="/img/Lake_1920x1080.jpg">
+<a class="download" data-src="/img/Lake_3840x2160.jpg" href="/img/Lake_UHD.jpg">Download UHD</a>
+</div>
+<meta property="og:image" content="https://cdn.example.com/Lake_og.jpg">
+</body></html>`
+
+func TestImageSelection(t *testing.T) {
+	const page = "https://bing.gifposter.com/detail/lake.html"
+	for _, test := range []struct {
+		name      string
+		selectors string
+		html      string
+		want      string
+	}{
+		{"UHD link by default", "a[href*='_UHD'][@href]", uhdDetailPage, "https://bing.gifposter.com/img/Lake_UHD.jpg"},
+		{"image without selectors", "", uhdDetailPage, "https://bing.gifposter.com/img/Lake_1920x1080.jpg"},
+		{"first matching selector", "a.missing[@href]; a.download[@data-src]; a[href*='_UHD'][@href]", uhdDetailPage, "https://bing.gifposter.com/img/Lake_3840x2160.jpg"},
+		{"image if no link matches", "a[href*='_UHD'][@href]", strings.Replace(uhdDetailPage, "_UHD", "_small", 1), "https://bing.gifposter.com/img/Lake_1920x1080.jpg"},
+		{"fallback selector", "", strings.Replace(uhdDetailPage, `id="bing_wallpaper"`, `class="photo"`, 1), "https://bing.gifposter.com/img/Lake_1920x1080.jpg"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			setFlag(t, imageSelectorsArg, test.selectors)
+			setFlag(t, &largeImageSelectors, nil)
+			if err := initImageSelectors(); err != nil {
+				t.Fatal(err)
+			}
+			wp, err := parseDetail(testDocument(t, test.html), page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wp.src != test.want {
+				t.Errorf("image %s, want %s", wp.src, test.want)
+			}
+		})
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	for _, test := range []struct {
+		value   string
+		want    selector
+		wantErr bool
+	}{
+		{"#bing_wallpaper[@src]", selector{"#bing_wallpaper", "src"}, false},
+		{" a[href*='_UHD'][@href] ", selector{"a[href*='_UHD']", "href"}, false},
+		{"div.detail time", selector{"div.detail time", ""}, false},
+		{"[@href]", selector{}, true},
+	} {
+		got, err := parseSelector(test.value)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("parseSelector(%q) = %+v, %v, want %+v", test.value, got, err, test.want)
+		}
+	}
+}
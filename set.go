@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Set wallpapers given as arguments or, without arguments, read line by line from stdin, e.g. from
+// a picker like rofi or fzf or a slideshow script. Each line is an image path or the date of a
+// stored wallpaper. Invalid ones are skipped with a warning.
+func setImages(args []string) error {
+	flags := flag.NewFlagSet("set", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: bingwallpaper set [path|YYYYMMDD...], without arguments read from stdin")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *noSet {
+		return fmt.Errorf("set: there is no setter with -no-set")
+	}
+	st := &store{path: wpFile}
+	entries, err := st.entries()
+	if err != nil {
+		return err
+	}
+	apply := func(value string) {
+		e, err := resolveImage(value, entries)
+		if err != nil {
+			log.Print(msg("set-skipped", value, err))
+			return
+		}
+		if err = setWallpaper(e.wallpaper()); err != nil {
+			log.Print(err)
+		}
+	}
+
+	if flags.NArg() > 0 {
+		for _, value := range flags.Args() {
+			apply(value)
+		}
+		return nil
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if value := strings.TrimSpace(scanner.Text()); value != "" {
+			apply(value)
+		}
+	}
+	return scanner.Err()
+}
+
+// Entry of the image given by a date or a path. Images which aren't stored get an entry with their
+// name as the description. The filename is relative to imgDir.
+func resolveImage(value string, entries []entry) (entry, error) {
+	if date, err := time.Parse(localDateLayout, value); err == nil {
+		for _, e := range entries {
+			if e.date.Equal(date) {
+				return e, checkImage(e)
+			}
+		}
+		return entry{}, fmt.Errorf("no wallpaper stored at %s", value)
+	}
+
+	path, err := filepath.Abs(expandPath(value))
+	if err != nil {
+		return entry{}, err
+	}
+	if _, _, err = imageSize(path); err != nil {
+		return entry{}, err
+	}
+	filename, err := filepath.Rel(imgDir, path)
+	if err != nil {
+		return entry{}, err
+	}
+	for _, e := range entries {
+		if e.filename == filename {
+			return e, nil
+		}
+	}
+	return entry{filename: filename, description: filepath.Base(path)}, nil
+}
+
+// Set any image, not necessarily from Bing, with the title and the description of the message given
+// by flags.
+func apply(args []string) error {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	title := flags.String("title", "", "title of the message, default the file name")
+	description := flags.String("description", "", "text of the message")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: bingwallpaper apply [-title title] [-description text] path")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("apply: expected one image path")
+	}
+	if *noSet {
+		return fmt.Errorf("apply: there is no setter with -no-set")
+	}
+	path, err := filepath.Abs(expandPath(flags.Arg(0)))
+	if err != nil {
+		return err
+	}
+	if _, _, err = imageSize(path); err != nil {
+		return fmt.Errorf("apply: %s", err)
+	}
+	filename, err := filepath.Rel(imgDir, path)
+	if err != nil {
+		return err
+	}
+	wp := wallpaper{filename: filename, title: *title, description: *description}
+	if wp.title == "" {
+		wp.title = filepath.Base(path)
+	}
+	if err = setWallpaper(wp); err != nil {
+		return fmt.Errorf("apply: %s", err)
+	}
+	return nil
+}
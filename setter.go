@@ -0,0 +1,494 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+// Fit modes of the wallpaper, the first one is the default.
+var fitModes = []string{"fill", "fit", "center", "tile", "stretch"}
+
+// Program which sets the wallpaper.
+type setter struct {
+	name string
+	// Fit mode to the argument of the program.
+	modes map[string]string
+	// Commands setting the image at path with the program's mode argument, run one after another.
+	commands func(path, mode string) [][]string
+	// Indexes of commands which may fail, e.g. settings which older versions of the program lack.
+	optional []int
+	// The program keeps running to show the wallpaper. It is started in the background and the
+	// instance started by the previous run is stopped.
+	persistent bool
+	// The program works without a display session.
+	noDisplay bool
+	// Output of the commands is logged, e.g. of a user's script.
+	logOutput bool
+	// Extra environment of the commands, set for each wallpaper by setWallpaper.
+	environ []string
+}
+
+var setters = []*setter{
+	{
+		name: "fbsetbg",
+		modes: map[string]string{
+			"fill": "-f", "fit": "-a", "center": "-c", "tile": "-t", "stretch": "-f",
+		},
+		commands: func(path, mode string) [][]string {
+			return [][]string{{"fbsetbg", mode, path}}
+		},
+	},
+	{
+		name: "feh",
+		modes: map[string]string{
+			"fill": "--bg-fill", "fit": "--bg-max", "center": "--bg-center", "tile": "--bg-tile", "stretch": "--bg-scale",
+		},
+		commands: func(path, mode string) [][]string {
+			return [][]string{{"feh", mode, path}}
+		},
+	},
+	{
+		name: "nitrogen",
+		modes: map[string]string{
+			"fill": "--set-zoom-fill", "fit": "--set-zoom", "center": "--set-centered", "tile": "--set-tiled", "stretch": "--set-scaled",
+		},
+		commands: func(path, mode string) [][]string {
+			return [][]string{{"nitrogen", mode, path}}
+		},
+	},
+	{
+		name: "xwallpaper",
+		modes: map[string]string{
+			"fill": "--zoom", "fit": "--maximize", "center": "--center", "tile": "--tile", "stretch": "--stretch",
+		},
+		commands: func(path, mode string) [][]string {
+			return [][]string{{"xwallpaper", mode, path}}
+		},
+	},
+	{
+		name: "swaybg",
+		modes: map[string]string{
+			"fill": "fill", "fit": "fit", "center": "center", "tile": "tile", "stretch": "stretch",
+		},
+		commands: func(path, mode string) [][]string {
+			return [][]string{{"swaybg", "-i", path, "-m", mode}}
+		},
+		persistent: true,
+	},
+	{
+		// hyprpaper only has cover (default), contain and tile.
+		name: "hyprpaper",
+		modes: map[string]string{
+			"fill": "", "fit": "contain:", "center": "", "tile": "tile:", "stretch": "",
+		},
+		commands: func(path, mode string) [][]string {
+			return [][]string{
+				{"hyprctl", "hyprpaper", "preload", path},
+				// Empty monitor name applies the wallpaper to all monitors.
+				{"hyprctl", "hyprpaper", "wallpaper", "," + mode + path},
+				// Otherwise every preloaded wallpaper stays in memory.
+				{"hyprctl", "hyprpaper", "unload", "unused"},
+			}
+		},
+	},
+	{
+		name: "gnome",
+		modes: map[string]string{
+			"fill": "zoom", "fit": "scaled", "center": "centered", "tile": "wallpaper", "stretch": "stretched",
+		},
+		commands: func(path, mode string) [][]string {
+			uri := (&neturl.URL{Scheme: "file", Path: path}).String()
+			return [][]string{
+				{"gsettings", "set", "org.gnome.desktop.background", "picture-options", mode},
+				{"gsettings", "set", "org.gnome.desktop.background", "picture-uri", uri},
+				{"gsettings", "set", "org.gnome.desktop.background", "picture-uri-dark", uri},
+			}
+		},
+		// GNOME 42 and later show picture-uri-dark in dark mode, before the key doesn't exist.
+		optional: []int{2},
+	},
+	{
+		// The fill mode of Plasma is kept, older versions of the program have no option for it.
+		name: "kde",
+		modes: map[string]string{
+			"fill": "", "fit": "", "center": "", "tile": "", "stretch": "",
+		},
+		commands: func(path, mode string) [][]string {
+			return [][]string{{"plasma-apply-wallpaperimage", path}}
+		},
+	},
+}
+
+// Find the setter by name, "auto" means detect it.
+func findSetter(name string) (*setter, error) {
+	if name == "auto" {
+		return detectSetter(), nil
+	}
+	for _, s := range setters {
+		if s.name == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown setter %q, expected one of: %s", name, strings.Join(setterNames(), ", "))
+}
+
+// Values accepted by -setter.
+func setterNames() []string {
+	names := []string{"auto"}
+	for _, s := range setters {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// Detect the setter from the session environment:
+//  1. hyprpaper if running under Hyprland;
+//  2. swaybg if running under Sway;
+//  3. gnome or kde in these desktops, by $XDG_CURRENT_DESKTOP;
+//  4. otherwise the first program found in $PATH: on X11 feh, nitrogen, xwallpaper, fbsetbg and
+//     gsettings, on Wayland gsettings and swaybg, e.g. for sessions started without
+//     $XDG_CURRENT_DESKTOP;
+//  5. fbsetbg if none is found.
+//
+// Wayland compositors and desktops are checked first, because X11 setters don't work there or are
+// overdrawn by the desktop. fbsetbg comes after the other X11 setters, because it is only a wrapper
+// which itself calls one of them. gsettings is installed with GLib on many other desktops, so it
+// is tried last on X11.
+func detectSetter() *setter {
+	desktop := strings.ToLower(os.Getenv("XDG_CURRENT_DESKTOP"))
+	name := "fbsetbg"
+	switch {
+	case os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != "":
+		name = "hyprpaper"
+	case os.Getenv("SWAYSOCK") != "" || strings.Contains(desktop, "sway"):
+		name = "swaybg"
+	case strings.Contains(desktop, "gnome"):
+		name = "gnome"
+	case strings.Contains(desktop, "kde"):
+		name = "kde"
+	default:
+		// Setters by the program they run.
+		probed := [][2]string{{"feh", "feh"}, {"nitrogen", "nitrogen"}, {"xwallpaper", "xwallpaper"}, {"fbsetbg", "fbsetbg"}, {"gnome", "gsettings"}}
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			probed = [][2]string{{"gnome", "gsettings"}, {"swaybg", "swaybg"}}
+		}
+		for _, p := range probed {
+			if _, err := exec.LookPath(p[1]); err == nil {
+				name = p[0]
+				break
+			}
+		}
+	}
+	s, _ := findSetter(name)
+	return s
+}
+
+// Setter running the command template of -setter-cmd. The template is split into arguments like
+// a shell would do it, but without running a shell, and {file} and {mode} placeholders are
+// replaced in every argument, so the path can't inject anything.
+func customSetter(template string) (*setter, error) {
+	args, err := splitCommand(template)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Empty setter command")
+	}
+	if !strings.Contains(template, "{file}") {
+		return nil, fmt.Errorf("Setter command %q has no {file} placeholder", template)
+	}
+	modes := make(map[string]string)
+	for _, m := range fitModes {
+		modes[m] = m
+	}
+	// Custom commands may not need a display, e.g. they may set the wallpaper on another machine.
+	return &setter{
+		name:      args[0],
+		modes:     modes,
+		noDisplay: true,
+		commands: func(path, mode string) [][]string {
+			replacer := strings.NewReplacer("{file}", path, "{mode}", mode)
+			command := make([]string, len(args))
+			for i, arg := range args {
+				command[i] = replacer.Replace(arg)
+			}
+			return [][]string{command}
+		},
+	}, nil
+}
+
+// Setter running the user's script with the image and its metadata in BW_* environment variables,
+// see setWallpaper. Like custom commands, scripts may not need a display.
+func scriptSetter(path string) (*setter, error) {
+	path = expandPath(path)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("Could not find set script: %s", err)
+	}
+	modes := make(map[string]string)
+	for _, m := range fitModes {
+		modes[m] = m
+	}
+	return &setter{
+		name:      filepath.Base(path),
+		modes:     modes,
+		noDisplay: true,
+		logOutput: true,
+		commands: func(file, mode string) [][]string {
+			return [][]string{{path}}
+		},
+	}, nil
+}
+
+// Split the command line into arguments. Arguments are separated with whitespace, single quotes
+// preserve everything literally, double quotes and backslash escapes work as in the shell.
+func splitCommand(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("Unterminated quote or escape in command %q", line)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// Whether there is no display session, according to -headless.
+func isHeadless() bool {
+	switch *headlessArg {
+	case "yes":
+		return true
+	case "no":
+		return false
+	}
+	return os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == ""
+}
+
+func checkHeadless(value string) error {
+	if value != "auto" && value != "yes" && value != "no" {
+		return fmt.Errorf("Unknown -headless value %q, expected auto, yes or no", value)
+	}
+	return nil
+}
+
+func checkFitMode(mode string) error {
+	for _, m := range fitModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown mode %q, expected one of: %s", mode, strings.Join(fitModes, ", "))
+}
+
+// Set the image at path as the wallpaper.
+func (s *setter) set(path, mode string) error {
+	commands := s.commands(path, s.modes[mode])
+	if !s.persistent {
+		for i, args := range commands {
+			explain("running %q", args)
+			cmd := exec.Command(args[0], args[1:]...)
+			if s.environ != nil {
+				cmd.Env = append(os.Environ(), s.environ...)
+			}
+			output, err := cmd.CombinedOutput()
+			if err != nil && slices.Contains(s.optional, i) {
+				explain("%s failed, which is allowed: %s", args[0], strings.TrimSpace(string(output)))
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("%s failed: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+			}
+			if text := strings.TrimSpace(string(output)); s.logOutput && text != "" {
+				log.Printf("%s: %s", s.name, text)
+			}
+		}
+		return nil
+	}
+
+	// Persistent programs are started with a single command.
+	args := commands[0]
+	explain("starting %q in the background", args)
+	cmd := exec.Command(args[0], args[1:]...)
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Could not start %s: %s", s.name, err)
+	}
+	s.stopPrevious()
+	pid := strconv.Itoa(cmd.Process.Pid)
+	if err := writeFileAtomic(s.pidFile(), []byte(pid+"\n"), 0644); err != nil {
+		return fmt.Errorf("Could not save pid of %s: %s", s.name, err)
+	}
+	return cmd.Process.Release()
+}
+
+func (s *setter) pidFile() string {
+	return filepath.Join(imgDir, "."+s.name+".pid")
+}
+
+// Stop the instance started by the previous run. The process name is checked, so that a pid reused
+// after reboot doesn't kill an unrelated process.
+func (s *setter) stopPrevious() {
+	data, err := os.ReadFile(s.pidFile())
+	if err != nil {
+		return
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return
+	}
+	name, err := processName(pid)
+	if err != nil {
+		log.Print(msg("previous-unchecked", s.name, pid, err))
+		return
+	}
+	if name != s.name {
+		return
+	}
+	if process, err := os.FindProcess(pid); err == nil {
+		process.Signal(syscall.SIGTERM)
+	}
+}
+
+// Mount point of the Linux process file system, see processName.
+var procDir = "/proc"
+
+// Name of the process, empty if there is none with the pid. Systems without the comm files of
+// Linux, e.g. the BSDs, are asked with ps.
+func processName(pid int) (string, error) {
+	comm, err := os.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "comm"))
+	if err == nil {
+		return strings.TrimSpace(string(comm)), nil
+	}
+	if _, err = os.Stat(filepath.Join(procDir, "self", "comm")); err == nil {
+		// The file system is there, the process isn't.
+		return "", nil
+	}
+	output, err := exec.Command("ps", "-o", "comm=", "-p", strconv.Itoa(pid)).Output()
+	name := strings.TrimSpace(string(output))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && name == "" {
+		// ps fails without output if there is no such process.
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("ps failed: %s", err)
+	}
+	// Some ps print the path of the program.
+	return filepath.Base(name), nil
+}
+
+// Setter as reported by the setters command.
+type setterInfo struct {
+	Name    string `json:"name"`
+	Program string `json:"program"`
+	Found   bool   `json:"found"`
+	// Fit modes the program has an argument for; the others fall back to fill.
+	Modes      []string `json:"modes"`
+	Persistent bool     `json:"persistent"`
+	NoDisplay  bool     `json:"no_display"`
+	Detected   bool     `json:"detected"`
+}
+
+// Capabilities of the built-in setters and whether their programs are in $PATH.
+func setterInfos() []setterInfo {
+	detected := detectSetter()
+	infos := make([]setterInfo, 0, len(setters))
+	for _, s := range setters {
+		info := setterInfo{
+			Name:       s.name,
+			Program:    s.commands("", "")[0][0],
+			Persistent: s.persistent,
+			NoDisplay:  s.noDisplay,
+			Detected:   s == detected,
+		}
+		_, err := exec.LookPath(info.Program)
+		info.Found = err == nil
+		for _, mode := range fitModes {
+			if mode == fitModes[0] || s.modes[mode] != s.modes[fitModes[0]] {
+				info.Modes = append(info.Modes, mode)
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Print the built-in setters, whether their programs are installed and what they support.
+func listSetters(args []string) error {
+	flags := flag.NewFlagSet("setters", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	infos := setterInfos()
+	if *asJSON {
+		return printJSON(infos)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTER\tPROGRAM\tFOUND\tMODES\tNOTES")
+	for _, info := range infos {
+		found := "no"
+		if info.Found {
+			found = "yes"
+		}
+		var notes []string
+		if info.Detected {
+			notes = append(notes, "detected")
+		}
+		if info.Persistent {
+			notes = append(notes, "keeps running")
+		}
+		if info.NoDisplay {
+			notes = append(notes, "no display needed")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", info.Name, info.Program, found, strings.Join(info.Modes, ","), strings.Join(notes, ", "))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Put scripts of the names into an empty $PATH. Each script appends its arguments to the log file
+// in the directory and fails if they contain $FAKE_FAIL.
+func fakePrograms(t *testing.T, names ...string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake programs are shell scripts")
+	}
+	dir := t.TempDir()
+	for _, name := range names {
+		script := "#!/bin/sh\necho \"$*\" >> " + filepath.Join(dir, "log") + "\n" +
+			"if [ -n \"$FAKE_FAIL\" ]; then case \"$*\" in *\"$FAKE_FAIL\"*) exit 1;; esac; fi\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+	t.Setenv("FAKE_FAIL", "")
+	return dir
+}
+
+func TestDetectSetter(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		env      map[string]string
+		programs []string
+		want     string
+	}{
+		{"Hyprland", map[string]string{"HYPRLAND_INSTANCE_SIGNATURE": "abc", "WAYLAND_DISPLAY": "wayland-1"}, []string{"gsettings"}, "hyprpaper"},
+		{"Sway", map[string]string{"SWAYSOCK": "/run/sway.sock", "WAYLAND_DISPLAY": "wayland-1"}, nil, "swaybg"},
+		{"Sway by desktop", map[string]string{"XDG_CURRENT_DESKTOP": "sway"}, nil, "swaybg"},
+		{"GNOME", map[string]string{"XDG_CURRENT_DESKTOP": "ubuntu:GNOME"}, []string{"feh"}, "gnome"},
+		{"KDE", map[string]string{"XDG_CURRENT_DESKTOP": "KDE"}, nil, "kde"},
+		{"X11 feh", map[string]string{"DISPLAY": ":0"}, []string{"feh", "nitrogen", "gsettings"}, "feh"},
+		{"X11 xwallpaper before fbsetbg", map[string]string{"DISPLAY": ":0"}, []string{"fbsetbg", "xwallpaper"}, "xwallpaper"},
+		{"X11 gsettings last", map[string]string{"DISPLAY": ":0"}, []string{"gsettings"}, "gnome"},
+		{"X11 gsettings after fbsetbg", map[string]string{"DISPLAY": ":0"}, []string{"gsettings", "fbsetbg"}, "fbsetbg"},
+		{"Wayland gsettings", map[string]string{"WAYLAND_DISPLAY": "wayland-0"}, []string{"gsettings", "swaybg", "feh"}, "gnome"},
+		{"Wayland swaybg", map[string]string{"WAYLAND_DISPLAY": "wayland-0"}, []string{"swaybg", "feh"}, "swaybg"},
+		{"Wayland without setters", map[string]string{"WAYLAND_DISPLAY": "wayland-0"}, []string{"feh"}, "fbsetbg"},
+		{"nothing found", nil, nil, "fbsetbg"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"HYPRLAND_INSTANCE_SIGNATURE", "SWAYSOCK", "XDG_CURRENT_DESKTOP", "WAYLAND_DISPLAY", "DISPLAY"} {
+				t.Setenv(name, test.env[name])
+			}
+			fakePrograms(t, test.programs...)
+			if got := detectSetter().name; got != test.want {
+				t.Errorf("setter %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSetterCommands(t *testing.T) {
+	const path = "/home/u/Images/bing-wallpapers/Lake Como.jpg"
+	for _, test := range []struct {
+		setter string
+		mode   string
+		want   [][]string
+	}{
+		{"feh", "fill", [][]string{{"feh", "--bg-fill", path}}},
+		{"fbsetbg", "center", [][]string{{"fbsetbg", "-c", path}}},
+		{"swaybg", "fit", [][]string{{"swaybg", "-i", path, "-m", "fit"}}},
+		{"hyprpaper", "tile", [][]string{
+			{"hyprctl", "hyprpaper", "preload", path},
+			{"hyprctl", "hyprpaper", "wallpaper", ",tile:" + path},
+			{"hyprctl", "hyprpaper", "unload", "unused"},
+		}},
+		{"gnome", "fill", [][]string{
+			{"gsettings", "set", "org.gnome.desktop.background", "picture-options", "zoom"},
+			{"gsettings", "set", "org.gnome.desktop.background", "picture-uri", "file:///home/u/Images/bing-wallpapers/Lake%20Como.jpg"},
+			{"gsettings", "set", "org.gnome.desktop.background", "picture-uri-dark", "file:///home/u/Images/bing-wallpapers/Lake%20Como.jpg"},
+		}},
+		{"kde", "stretch", [][]string{{"plasma-apply-wallpaperimage", path}}},
+	} {
+		s, err := findSetter(test.setter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.commands(path, s.modes[test.mode]); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s -mode %s: %q, want %q", test.setter, test.mode, got, test.want)
+		}
+	}
+	if _, err := findSetter("xsetroot"); err == nil {
+		t.Error("unknown setter found")
+	}
+}
+
+func TestSetterEveryMode(t *testing.T) {
+	for _, s := range setters {
+		for _, mode := range fitModes {
+			if _, ok := s.modes[mode]; !ok {
+				t.Errorf("setter %s lacks mode %s", s.name, mode)
+			}
+		}
+	}
+}
+
+func TestGnomeDarkKeyOptional(t *testing.T) {
+	s, err := findSetter("gnome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		fail    string
+		wantErr bool
+		wantRun int
+	}{
+		{"all keys", "", false, 3},
+		// GNOME before 42 has no picture-uri-dark.
+		{"without the dark key", "picture-uri-dark", false, 3},
+		{"failing picture-uri", "picture-uri file:", true, 2},
+		{"failing picture-options", "picture-options", true, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := fakePrograms(t, "gsettings")
+			t.Setenv("FAKE_FAIL", test.fail)
+			err := s.set("/img/lake.jpg", "fill")
+			if (err != nil) != test.wantErr {
+				t.Errorf("error %v, want error %v", err, test.wantErr)
+			}
+			log, _ := os.ReadFile(filepath.Join(dir, "log"))
+			if got := strings.Count(string(log), "\n"); got != test.wantRun {
+				t.Errorf("gsettings ran %d times, want %d:\n%s", got, test.wantRun, log)
+			}
+		})
+	}
+}
+
+func TestCustomSetter(t *testing.T) {
+	for _, test := range []struct {
+		template string
+		want     []string
+		wantErr  bool
+	}{
+		{"xsetbg -{mode} {file}", []string{"xsetbg", "-fill", "/img/a b.jpg"}, false},
+		{`sh -c 'cp "$0" /srv/wp.jpg' {file}`, []string{"sh", "-c", `cp "$0" /srv/wp.jpg`, "/img/a b.jpg"}, false},
+		{`set\ bg "--image={file}"`, []string{"set bg", "--image=/img/a b.jpg"}, false},
+		{"xsetbg -fill", nil, true},
+		{"", nil, true},
+		{`xsetbg "{file}`, nil, true},
+	} {
+		s, err := customSetter(test.template)
+		if (err != nil) != test.wantErr {
+			t.Errorf("customSetter(%q): %v, want error %v", test.template, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := s.commands("/img/a b.jpg", s.modes["fill"]); !reflect.DeepEqual(got, [][]string{test.want}) {
+			t.Errorf("customSetter(%q) runs %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+// Without the comm files of Linux, e.g. on FreeBSD, the name of the previous instance is asked of ps.
+func TestProcessNameWithoutProc(t *testing.T) {
+	setFlag(t, &procDir, t.TempDir())
+	dir := fakePrograms(t, "ps")
+	script := "#!/bin/sh\ncase \"$*\" in *\" 42\") echo /usr/local/bin/swaybg;; *) exit 1;; esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "ps"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		pid  int
+		want string
+	}{
+		{42, "swaybg"},
+		{43, ""},
+	} {
+		if got, err := processName(test.pid); err != nil || got != test.want {
+			t.Errorf("processName(%d) = %q, %v, want %q", test.pid, got, err, test.want)
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, "ps")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := processName(42); err == nil {
+		t.Errorf("processName without ps = %q, want an error", got)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Print the stored entry of the date. Only the store is read.
+func show(args []string) error {
+	flags := flag.NewFlagSet("show", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: bingwallpaper show [-json] YYYYMMDD")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("show: expected one date")
+	}
+	date, err := time.Parse(localDateLayout, flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("show: malformed date: %s", err)
+	}
+	e, ok, err := (&store{path: wpFile}).get(date)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("show: no wallpaper stored at %s", flags.Arg(0))
+	}
+	if *asJSON {
+		return printJSON(e.toJSON())
+	}
+
+	// Title and description are stored joined by logWallpaper.
+	title, description, _ := strings.Cut(e.description, ".  ")
+	size := ""
+	if e.width > 0 && e.height > 0 {
+		size = fmt.Sprintf("%dx%d", e.width, e.height)
+	}
+	downloaded := ""
+	if e.bytes > 0 {
+		downloaded = fmt.Sprintf("%d bytes", e.bytes)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, field := range [][2]string{
+		{"date", e.date.Format(localDateLayout)},
+		{"filename", e.filename},
+		{"title", title},
+		{"description", description},
+		{"author", e.author},
+		{"location", e.location},
+		{"page", e.page},
+		{"image", e.image},
+		{"mirror", e.mirror},
+		{"bing id", e.bingID},
+		{"size", size},
+		{"sha256", e.sha256},
+		{"format", e.format},
+		{"downloaded", downloaded},
+	} {
+		fmt.Fprintf(w, "%s:\t%s\n", field[0], field[1])
+	}
+	return w.Flush()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Metadata of an image passed to sinks along with its content.
+type imageMeta struct {
+	date        time.Time
+	contentType string
+	// Url the image was downloaded from.
+	source string
+	// Check of the complete image at path before it replaces an existing one, e.g. of its size. An
+	// error is returned by put, which keeps the existing image.
+	check func(path string) error
+}
+
+// Target downloaded images are written to.
+type imageSink interface {
+	// Store the content read from r under the name, replacing an existing image.
+	put(name string, r io.Reader, meta imageMeta) error
+	// Whether an image with the name is stored.
+	exists(name string) (bool, error)
+}
+
+// Sink writing images into a local directory, the image directory by default.
+type localSink struct {
+	dir string
+}
+
+// The image is written into a temporary file which replaces the existing one only when it is
+// complete, like writeFileAtomic, so a failed download again keeps the old image.
+func (s *localSink) put(name string, r io.Reader, meta imageMeta) error {
+	path := filepath.Join(s.dir, name)
+	output, err := os.CreateTemp(s.dir, "."+name+"-*")
+	if err != nil {
+		return fmt.Errorf("Could not create file %s: %s", path, err)
+	}
+	defer os.Remove(output.Name())
+	if _, err = io.Copy(output, r); err != nil {
+		output.Close()
+		return fmt.Errorf("Could not write image to %s: %s", path, err)
+	}
+	if err = output.Close(); err != nil {
+		return fmt.Errorf("Could not write image to %s: %s", path, err)
+	}
+	if meta.check != nil {
+		if err = meta.check(output.Name()); err != nil {
+			return err
+		}
+	}
+	if err = os.Chmod(output.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(output.Name(), path)
+}
+
+func (s *localSink) exists(name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Writer counting bytes written through it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Record about a downloaded wallpaper.
+type entry struct {
+	date        time.Time
+	filename    string
+	description string
+	// Url of the page with photo.
+	page string
+	// Url of the image.
+	image string
+	// Url of the image on the mirror if the mirror served it.
+	mirror string
+	// Id of the image at Bing, the same in all markets and for all names of the file.
+	bingID string
+	// Place of the photo, see -location-pattern.
+	location string
+	// Dimensions of the image, zero if unknown.
+	width  int
+	height int
+	// SHA-256 checksum of the file, hex-encoded.
+	sha256 string
+	// Format of the downloaded image, e.g. jpg.
+	format string
+	// Copyright holder of the photo.
+	author string
+	// Bytes downloaded for the date, pages and image, zero if unknown.
+	bytes int64
+}
+
+// Wallpaper records kept in a text file, one per line, in the format
+// YYYYMMDD <wallpaper-file-name> <description>[\t<key>=<value>...].
+// Optional fields follow the description separated with tabs, so lines written by older versions
+// are read as they are, and scripts reading the first columns keep working. Keys are:
+//
+//	page     url of the page with photo
+//	image    url of the image
+//	mirror   url of the image on the mirror which served it, see -mirror-url
+//	bingid   id of the image at Bing, e.g. OHR.Hallstatt_EN-US1234567890
+//	location place of the photo, e.g. Banff National Park, Canada
+//	size     dimensions of the image, <width>x<height>
+//	sha256   checksum of the file
+//	format   format of the downloaded image, e.g. jpg
+//	author   copyright holder of the photo
+//	bytes    bytes downloaded for the date, pages and image together
+//
+// The file is always sorted by date from the newest to the oldest, so the first line is the newest
+// wallpaper regardless of the order in which wallpapers were downloaded. With -store-format jsonl
+// each line is a JSON object with the same fields instead, see jsonEntry.
+type store struct {
+	path string
+	// Format of the lines, text or jsonl; empty for -store-format.
+	format string
+	// Entries by date, read by the first get so that a run looking up every date of the listing
+	// reads and decompresses the file once. Writes through the store drop them.
+	byDate map[time.Time]entry
+}
+
+func (s *store) jsonl() bool {
+	if s.format != "" {
+		return s.format == "jsonl"
+	}
+	return *storeFormat == "jsonl"
+}
+
+// Read all entries. Missing file means empty store.
+func (s *store) entries() ([]entry, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, withKind(errStore, fmt.Errorf("Could not open %s: %w", s.path, err))
+	}
+	defer f.Close()
+	r, err := decompress(s.path, f)
+	if err != nil {
+		return nil, withKind(errStore, fmt.Errorf("Could not read %s: %w", s.path, err))
+	}
+	defer r.Close()
+
+	entries := make([]entry, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		// Files created by older versions start with an empty line. The seal isn't a record.
+		if line == "" || strings.HasPrefix(line, sealPrefix) {
+			continue
+		}
+		parse := parseEntry
+		if s.jsonl() {
+			parse = parseJSONEntry
+		}
+		e, err := parse(line)
+		if err != nil {
+			return nil, withKind(errStore, fmt.Errorf("%s:%d: %s", s.path, n, err))
+		}
+		entries = append(entries, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, withKind(errStore, fmt.Errorf("Could not read %s: %w", s.path, err))
+	}
+	return entries, nil
+}
+
+// Date of the newest entry. Zero time if the store is empty.
+func (s *store) newest() (time.Time, error) {
+	entries, err := s.entries()
+	if err != nil || len(entries) == 0 {
+		return time.Time{}, err
+	}
+	return entries[0].date, nil
+}
+
+// Entry at the date and whether it exists.
+func (s *store) get(date time.Time) (entry, bool, error) {
+	if s.byDate == nil {
+		entries, err := s.entries()
+		if err != nil {
+			return entry{}, false, err
+		}
+		s.byDate = make(map[time.Time]entry, len(entries))
+		for _, e := range entries {
+			s.byDate[e.date.UTC()] = e
+		}
+	}
+	e, ok := s.byDate[date.UTC()]
+	return e, ok, nil
+}
+
+// Add an entry, replacing the one at the same date, and rewrite the file sorted.
+func (s *store) add(e entry) error {
+	return s.update(func(entries []entry) []entry {
+		for i := range entries {
+			if entries[i].date.Equal(e.date) {
+				entries = append(entries[:i], entries[i+1:]...)
+				break
+			}
+		}
+		return append(entries, e)
+	})
+}
+
+// Guards read-modify-write cycles of stores within the process, the lock file guards them against
+// other processes, e.g. a cron run and a fetch command.
+var storeMu sync.Mutex
+
+// Take the exclusive lock of the store. The lock is on a separate file, because writes replace the
+// wallpapers file by renaming. Returns the function releasing it.
+func (s *store) lock() (func(), error) {
+	storeMu.Lock()
+	f, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		storeMu.Unlock()
+		return nil, withKind(errStore, fmt.Errorf("Could not open lock file: %w", err))
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		storeMu.Unlock()
+		return nil, withKind(errStore, fmt.Errorf("Could not lock %s: %w", s.path, err))
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+		storeMu.Unlock()
+	}, nil
+}
+
+// Read the entries, change them with fn and write them back, holding the lock, so concurrent
+// updates aren't lost.
+func (s *store) update(fn func(entries []entry) []entry) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := s.entries()
+	if err != nil {
+		return err
+	}
+	return s.write(fn(entries))
+}
+
+// Sort entries from the newest to the oldest and replace the file with them atomically. Callers
+// which read the entries before hold the lock, see update.
+func (s *store) write(entries []entry) error {
+	s.byDate = nil
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].date.After(entries[j].date)
+	})
+	var b strings.Builder
+	for _, e := range entries {
+		if s.jsonl() {
+			b.WriteString(formatJSONEntry(e))
+		} else {
+			b.WriteString(formatEntry(e))
+		}
+		b.WriteByte('\n')
+	}
+	if *storeChecksum {
+		b.WriteString(seal(len(entries), sha256.Sum256([]byte(b.String()))))
+		b.WriteByte('\n')
+	}
+	explain("writing %d entries to %s", len(entries), s.path)
+	data, err := compress(s.path, []byte(b.String()))
+	if err != nil {
+		return withKind(errStore, fmt.Errorf("Could not compress %s: %w", s.path, err))
+	}
+	if err := writeFileAtomic(s.path, data, 0644); err != nil {
+		return withKind(errStore, fmt.Errorf("Could not write %s: %w", s.path, err))
+	}
+	return nil
+}
+
+// Last line of the file written with -store-checksum: the number of records and the SHA-256 of
+// their lines. A file cut short, e.g. by a full disk, loses it or doesn't match it. Versions
+// without the seal report it as a malformed line, so it is only written if asked for.
+const sealPrefix = "# records="
+
+func seal(records int, sum [sha256.Size]byte) string {
+	return fmt.Sprintf("%s%d sha256=%x", sealPrefix, records, sum)
+}
+
+// Check that the file matches its seal. With -store-checksum a file without one is reported too,
+// since it is the seal which a file cut short loses, unless the file was never sealed. Missing
+// file passes.
+func (s *store) checkSeal() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return withKind(errStore, fmt.Errorf("Could not open %s: %w", s.path, err))
+	}
+	defer f.Close()
+	r, err := decompress(s.path, f)
+	if err != nil {
+		return withKind(errStore, fmt.Errorf("Could not read %s: %w", s.path, err))
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	records := 0
+	found, want := "", ""
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, sealPrefix) {
+			var sum [sha256.Size]byte
+			copy(sum[:], hash.Sum(nil))
+			found, want = line, seal(records, sum)
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if found != "" {
+			return withKind(errStore, fmt.Errorf("%s has records after its checksum line", s.path))
+		}
+		records++
+		hash.Write([]byte(line + "\n"))
+	}
+	if err = scanner.Err(); err != nil {
+		return withKind(errStore, fmt.Errorf("Could not read %s: %w", s.path, err))
+	}
+	switch {
+	case found == "" && *storeChecksum && records > 0:
+		return withKind(errStore, fmt.Errorf("%s has no checksum line, it may be cut short", s.path))
+	case found != want:
+		return withKind(errStore, fmt.Errorf("%s doesn't match its checksum line, it is damaged: %d records, %q", s.path, records, found))
+	}
+	return nil
+}
+
+func parseEntry(line string) (entry, error) {
+	var e entry
+	fields := strings.Split(line, "\t")
+	columns := strings.SplitN(fields[0], " ", 3)
+	if len(columns) < 2 {
+		return e, fmt.Errorf("malformed line %q", line)
+	}
+	date, err := time.Parse(localDateLayout, columns[0])
+	if err != nil {
+		return e, fmt.Errorf("malformed date %q", columns[0])
+	}
+	e.date = date
+	e.filename = columns[1]
+	if len(columns) == 3 {
+		e.description = columns[2]
+	}
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "page":
+			e.page = value
+		case "image":
+			e.image = value
+		case "mirror":
+			e.mirror = value
+		case "bingid":
+			e.bingID = value
+		case "location":
+			e.location = value
+		case "size":
+			fmt.Sscanf(value, "%dx%d", &e.width, &e.height)
+		case "sha256":
+			e.sha256 = value
+		case "format":
+			e.format = value
+		case "author":
+			e.author = value
+		case "bytes":
+			e.bytes, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return e, nil
+}
+
+// Line breaks would split the record and tabs separate fields, so they are replaced with spaces.
+var fieldReplacer = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ", "\t", " ")
+
+func formatEntry(e entry) string {
+	line := fmt.Sprintf("%s %s %s", e.date.Format(localDateLayout), e.filename, fieldReplacer.Replace(e.description))
+	field := func(key, value string) {
+		if value != "" {
+			line += "\t" + key + "=" + fieldReplacer.Replace(value)
+		}
+	}
+	field("page", e.page)
+	field("image", e.image)
+	field("mirror", e.mirror)
+	field("bingid", e.bingID)
+	field("location", e.location)
+	if e.width > 0 && e.height > 0 {
+		field("size", fmt.Sprintf("%dx%d", e.width, e.height))
+	}
+	field("sha256", e.sha256)
+	field("format", e.format)
+	field("author", e.author)
+	if e.bytes > 0 {
+		field("bytes", strconv.FormatInt(e.bytes, 10))
+	}
+	return line
+}
+
+// Wallpaper of the entry, with the title and the description split as they are joined by
+// logWallpaper.
+func (e entry) wallpaper() wallpaper {
+	title, description, _ := strings.Cut(e.description, ".  ")
+	return wallpaper{
+		date:        e.date,
+		title:       title,
+		description: description,
+		author:      e.author,
+		page:        e.page,
+		src:         e.image,
+		mirror:      e.mirror,
+		bingID:      e.bingID,
+		location:    e.location,
+		filename:    e.filename,
+		width:       e.width,
+		height:      e.height,
+		sha256:      e.sha256,
+		format:      e.format,
+		bytes:       e.bytes,
+	}
+}
+
+// Reader of the file's content, decompressed if the path ends with .gz or .zst.
+func decompress(path string, r io.Reader) (io.ReadCloser, error) {
+	switch filepath.Ext(path) {
+	case ".gz":
+		return gzip.NewReader(r)
+	case ".zst":
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return io.NopCloser(r), nil
+}
+
+// Data compressed if the path ends with .gz or .zst.
+func compress(path string, data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch filepath.Ext(path) {
+	case ".gz":
+		w = gzip.NewWriter(&b)
+	case ".zst":
+		encoder, err := zstd.NewWriter(&b)
+		if err != nil {
+			return nil, err
+		}
+		w = encoder
+	default:
+		return data, nil
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Version of the format written by store. Version 1 is the format of older versions: three columns
+// only, possibly unsorted and starting with an empty line.
+const storeVersion = 2
+
+// File holding the version of the format of wpFile. It is kept apart, so the first line of wpFile
+// stays the newest wallpaper for scripts.
+func (s *store) versionFile() string {
+	return s.path + ".version"
+}
+
+// Upgrade a file written by an older version to the current format. The old file is kept as
+// <path>.v<version>.bak. Missing file and the current version are left alone.
+func (s *store) migrate() error {
+	version := 1
+	data, err := os.ReadFile(s.versionFile())
+	if err == nil {
+		if version, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+			return fmt.Errorf("Malformed version in %s: %s", s.versionFile(), err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Could not read %s: %s", s.versionFile(), err)
+	}
+	if version == storeVersion {
+		return nil
+	}
+	if version > storeVersion {
+		return fmt.Errorf("%s is written by a newer version (format %d), refusing to change it", s.path, version)
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	old, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return withKind(errStore, fmt.Errorf("Could not read %s: %w", s.path, err))
+	}
+	if err == nil {
+		entries, err := s.entries()
+		if err != nil {
+			return err
+		}
+		backup := fmt.Sprintf("%s.v%d.bak", s.path, version)
+		if err = writeFileAtomic(backup, old, 0644); err != nil {
+			return fmt.Errorf("Could not back up %s: %s", s.path, err)
+		}
+		if err = s.write(entries); err != nil {
+			return err
+		}
+		log.Print(msg("store-migrated", s.path, version, storeVersion, backup))
+	}
+	return writeFileAtomic(s.versionFile(), []byte(strconv.Itoa(storeVersion)+"\n"), 0644)
+}
+
+// File holding the date of the newest wallpaper when it was downloaded but couldn't be set.
+func unsetFile() string {
+	return filepath.Join(imgDir, ".unset")
+}
+
+// Record whether the wallpaper of the date is still to be set.
+func writeUnset(date time.Time, unset bool) error {
+	if !unset {
+		err := os.Remove(unsetFile())
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Could not remove %s: %s", unsetFile(), err)
+		}
+		return nil
+	}
+	return writeFileAtomic(unsetFile(), []byte(date.Format(localDateLayout)+"\n"), 0644)
+}
+
+// Set the newest wallpaper if the run which downloaded it couldn't, e.g. because the desktop
+// wasn't ready, or was outside -set-window. Wallpapers set by hand in between aren't replaced,
+// since the file is only kept for the newest date.
+func retryUnset(s *store, newest time.Time) {
+	// Without a display it would only fail again, and say so every run.
+	if *noSet || (isHeadless() && !wallpaperSetter.noDisplay) || !setWindowRange.contains(time.Now()) {
+		return
+	}
+	data, err := os.ReadFile(unsetFile())
+	if err != nil {
+		return
+	}
+	date, err := time.Parse(localDateLayout, strings.TrimSpace(string(data)))
+	if err != nil || !date.Equal(newest) {
+		check(writeUnset(date, false))
+		return
+	}
+	e, ok, err := s.get(date)
+	check(err)
+	if ok {
+		err = setWallpaper(e.wallpaper())
+		if err != nil {
+			log.Print(err)
+		}
+		check(writeUnset(date, err != nil))
+	}
+}
+
+// File holding the date up to which the archive is complete, when it lags behind the newest entry
+// because some older date failed to download.
+func lastDateFile() string {
+	return wpFile + ".lastdate"
+}
+
+// Fetch the date after which wallpapers have to be downloaded: the date from lastDateFile if there
+// are gaps, otherwise the newest entry.
+func readLastDate(s *store) (time.Time, error) {
+	data, err := os.ReadFile(lastDateFile())
+	if err == nil {
+		date, err := time.Parse(localDateLayout, strings.TrimSpace(string(data)))
+		if err != nil {
+			return date, fmt.Errorf("Malformed date in %s: %s", lastDateFile(), err)
+		}
+		return date, nil
+	}
+	if !os.IsNotExist(err) {
+		return time.Time{}, fmt.Errorf("Could not read %s: %s", lastDateFile(), err)
+	}
+	return s.newest()
+}
+
+// Save the date up to which the archive is complete. Zero date means there are no gaps.
+func writeLastDate(date time.Time) error {
+	if date.IsZero() {
+		err := os.Remove(lastDateFile())
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Could not remove %s: %s", lastDateFile(), err)
+		}
+		return nil
+	}
+	return writeFileAtomic(lastDateFile(), []byte(date.Format(localDateLayout)+"\n"), 0644)
+}
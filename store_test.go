@@ -0,0 +1,315 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Dates of the entries, YYYYMMDD separated by spaces.
+func entryDates(entries []entry) string {
+	dates := make([]string, len(entries))
+	for i, e := range entries {
+		dates[i] = e.date.Format(localDateLayout)
+	}
+	return strings.Join(dates, " ")
+}
+
+func TestStoreKeepsEntriesSorted(t *testing.T) {
+	dir := t.TempDir()
+	st := &store{path: filepath.Join(dir, "wallpapers")}
+	for _, e := range []entry{
+		{date: mustParseDate(t, "20240102"), filename: "b.jpg"},
+		{date: mustParseDate(t, "20240105"), filename: "e.jpg"},
+		{date: mustParseDate(t, "20240101"), filename: "a.jpg"},
+		{date: mustParseDate(t, "20240105"), filename: "e2.jpg"},
+	} {
+		if err := st.add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := st.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entryDates(entries), "20240105 20240102 20240101"; got != want {
+		t.Errorf("dates %s, want %s", got, want)
+	}
+	if entries[0].filename != "e2.jpg" {
+		t.Errorf("entry at 20240105 is %s, want the replacing e2.jpg", entries[0].filename)
+	}
+}
+
+func TestStoreGetSeesOwnWrites(t *testing.T) {
+	st := &store{path: filepath.Join(t.TempDir(), "wallpapers")}
+	date := mustParseDate(t, "20240101")
+	if _, ok, err := st.get(date); err != nil || ok {
+		t.Fatalf("get on an empty store: %v, %v", ok, err)
+	}
+	if err := st.add(entry{date: date, filename: "a.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	e, ok, err := st.get(date)
+	if err != nil || !ok || e.filename != "a.jpg" {
+		t.Errorf("get after add: %+v, %v, %v", e, ok, err)
+	}
+}
+
+func TestMigrateLegacyFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wallpapers")
+	// Format 1: an empty first line, three columns, the newest processed date on top.
+	legacy := "\n20240102 b.jpg Lake.  A lake in the mountains\n20240103 c.jpg Fox.  A fox\n20240101 a.jpg Sea.\n"
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st := &store{path: path}
+	if err := st.migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := os.ReadFile(path + ".v1.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(backup) != legacy {
+		t.Errorf("backup %q, want the legacy file %q", backup, legacy)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "20240103 c.jpg Fox.  A fox\n20240102 b.jpg Lake.  A lake in the mountains\n20240101 a.jpg Sea.\n"
+	if string(data) != want {
+		t.Errorf("migrated file %q, want %q", data, want)
+	}
+	version, err := os.ReadFile(st.versionFile())
+	if err != nil || strings.TrimSpace(string(version)) != strconv.Itoa(storeVersion) {
+		t.Errorf("version file %q, %v, want %d", version, err, storeVersion)
+	}
+
+	// The current version is left alone.
+	if err = st.migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path + ".v2.bak"); !os.IsNotExist(err) {
+		t.Errorf("current version backed up again: %v", err)
+	}
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	st := &store{path: filepath.Join(t.TempDir(), "wallpapers")}
+	if err := os.WriteFile(st.versionFile(), []byte(strconv.Itoa(storeVersion+1)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.migrate(); err == nil {
+		t.Error("file of a newer version migrated")
+	}
+}
+
+func TestMigrateMissingFile(t *testing.T) {
+	st := &store{path: filepath.Join(t.TempDir(), "wallpapers")}
+	if err := st.migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(st.path); !os.IsNotExist(err) {
+		t.Errorf("wallpapers file created: %v", err)
+	}
+}
+
+// Entries with every field set.
+func sampleEntries(t *testing.T) []entry {
+	return []entry{
+		{
+			date: mustParseDate(t, "20240102"), filename: "Lake_1920x1080.jpg", description: "Lake.  A lake in the mountains",
+			page: "https://bing.gifposter.com/detail/lake.html", image: "https://bing.gifposter.com/img/Lake_1920x1080.jpg",
+			mirror: "https://mirror.example/Lake.jpg", bingID: "OHR.Lake_EN-US123", location: "Alps, Austria",
+			width: 1920, height: 1080, sha256: strings.Repeat("ab", 32), format: "jpg", author: "Photographer", bytes: 123456,
+		},
+		{date: mustParseDate(t, "20240101"), filename: "Sea.jpg", description: "Sea."},
+	}
+}
+
+func TestStoreCompressedRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		magic string
+	}{
+		{"wallpapers", "20240102"},
+		{"wallpapers.gz", "\x1f\x8b"},
+		{"wallpapers.zst", "\x28\xb5\x2f\xfd"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			st := &store{path: filepath.Join(t.TempDir(), test.name)}
+			want := sampleEntries(t)
+			if err := st.write(want); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(st.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(data), test.magic) {
+				t.Errorf("file starts with %q, want %q", data[:min(len(data), 8)], test.magic)
+			}
+			got, err := st.entries()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("entries read back\n%+v\nwant\n%+v", got, want)
+			}
+			newest, err := st.newest()
+			if err != nil || !newest.Equal(want[0].date) {
+				t.Errorf("newest %s, %v, want %s", newest.Format(localDateLayout), err, want[0].date.Format(localDateLayout))
+			}
+		})
+	}
+}
+
+func TestStoreConcurrentAdds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallpapers")
+	const workers, perWorker = 20, 10
+	first := mustParseDate(t, "20240101")
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each worker has a store of its own, like a run and a command would.
+			st := &store{path: path}
+			for i := range perWorker {
+				date := first.AddDate(0, 0, i*workers+w)
+				errs <- st.add(entry{date: date, filename: date.Format(localDateLayout) + ".jpg"})
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := (&store{path: path}).entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != workers*perWorker {
+		t.Fatalf("%d entries, want %d", len(entries), workers*perWorker)
+	}
+	for i, e := range entries {
+		if want := first.AddDate(0, 0, len(entries)-1-i); !e.date.Equal(want) {
+			t.Fatalf("entry %d at %s, want %s: lost or duplicated lines", i, e.date.Format(localDateLayout), want.Format(localDateLayout))
+		}
+	}
+}
+
+// The run exits early if the newest stored date isn't before today.
+func TestStoreNewestUpToDate(t *testing.T) {
+	today := mustParseDate(t, "20240105")
+	for _, test := range []struct {
+		name     string
+		content  *string
+		want     string
+		upToDate bool
+	}{
+		{"missing file", nil, "00010101", false},
+		{"empty file", ptr(""), "00010101", false},
+		{"blank lines only", ptr("\n\n"), "00010101", false},
+		{"blank first line", ptr("\n20240105 e.jpg Fox\n20240104 d.jpg Lake\n"), "20240105", true},
+		{"today entry", ptr("20240105 e.jpg Fox\n20240104 d.jpg Lake\n"), "20240105", true},
+		{"yesterday entry", ptr("20240104 d.jpg Lake\n"), "20240104", false},
+		{"without final newline", ptr("20240105 e.jpg"), "20240105", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			st := &store{path: filepath.Join(t.TempDir(), "wallpapers")}
+			if test.content != nil {
+				if err := os.WriteFile(st.path, []byte(*test.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			newest, err := st.newest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := newest.Format(localDateLayout); got != test.want {
+				t.Errorf("newest %s, want %s", got, test.want)
+			}
+			if upToDate := !newest.Before(today); upToDate != test.upToDate {
+				t.Errorf("up to date %v, want %v", upToDate, test.upToDate)
+			}
+		})
+	}
+}
+
+func TestStoreNewestCutShort(t *testing.T) {
+	// A line cut short within the date, e.g. by a full disk, is an error rather than no date.
+	st := &store{path: filepath.Join(t.TempDir(), "wallpapers")}
+	if err := os.WriteFile(st.path, []byte("202401"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if newest, err := st.newest(); err == nil {
+		t.Errorf("newest %s of a line cut short, want an error", newest.Format(localDateLayout))
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// Whatever order downloads finish in, the file is sorted from the newest date. The adds start
+// together and finish in the order, or all at once without one.
+func TestStoreOrderIndependentOfCompletion(t *testing.T) {
+	first := mustParseDate(t, "20240101")
+	for _, order := range [][]int{
+		{0, 1, 2, 3, 4, 5},
+		{5, 4, 3, 2, 1, 0},
+		{3, 0, 5, 1, 4, 2},
+		nil,
+	} {
+		path := filepath.Join(t.TempDir(), "wallpapers")
+		const days = 6
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for day := range days {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				// The download of the day takes longer the later it finishes.
+				if i := slices.Index(order, day); i > 0 {
+					time.Sleep(time.Duration(i) * 5 * time.Millisecond)
+				}
+				date := first.AddDate(0, 0, day)
+				if err := (&store{path: path}).add(entry{date: date, filename: date.Format(localDateLayout) + ".jpg"}); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != days {
+			t.Fatalf("order %v: %d lines, want %d", order, len(lines), days)
+		}
+		for i := 1; i < len(lines); i++ {
+			if lines[i-1][:8] <= lines[i][:8] {
+				t.Errorf("order %v: line %q follows %q, want strictly descending dates", order, lines[i], lines[i-1])
+			}
+		}
+	}
+}
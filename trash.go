@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Trash directory, -trash-dir or imgDir/.trash.
+func trashDir() string {
+	if *trashDirArg != "" {
+		return *trashDirArg
+	}
+	return filepath.Join(imgDir, ".trash")
+}
+
+// Remove the image from imgDir. By default it is moved into the trash directory, so a misconfigured
+// cleanup can be undone; -hard-delete removes it. The file of the newest entry, which is the
+// current wallpaper, and the file of today's entry are never removed.
+func removeImage(filename string) error {
+	entries, err := (&store{path: wpFile}).entries()
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		if e.filename == filename && (i == 0 || e.date.Equal(today)) {
+			return fmt.Errorf("Refusing to remove %s: it is the current or today's wallpaper", filename)
+		}
+	}
+
+	path := filepath.Join(imgDir, filename)
+	if *hardDelete {
+		return os.Remove(path)
+	}
+	dir := trashDir()
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Could not create trash directory: %s", err)
+	}
+	target := filepath.Join(dir, filename)
+	if _, err = os.Stat(target); err == nil {
+		ext := filepath.Ext(filename)
+		target = filepath.Join(dir, fmt.Sprintf("%s.%d%s", strings.TrimSuffix(filename, ext), time.Now().Unix(), ext))
+	}
+	if err = os.Rename(path, target); err != nil {
+		return fmt.Errorf("Could not move %s to trash: %s", filename, err)
+	}
+	return nil
+}
+
+// File removed by a cleanup command, as listed before removing it.
+type removal struct {
+	Name string `json:"name"`
+	// Date in the name, or the day the file was last modified if the name has none.
+	Date string `json:"date"`
+	Size int64  `json:"size"`
+}
+
+// Summary of the files removed by a cleanup command.
+type removals struct {
+	Files []removal `json:"files"`
+	Total int64     `json:"total"`
+}
+
+// Dates and sizes of the files in the directory. Directories count with the size of their files.
+func listRemovals(dir string, names []string) (removals, error) {
+	list := removals{Files: make([]removal, 0, len(names))}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return list, err
+		}
+		r := removal{Name: name, Size: info.Size(), Date: info.ModTime().Format(localDateLayout)}
+		if date, ok := dateOfName(name); ok {
+			r.Date = date.Format(localDateLayout)
+		}
+		if info.IsDir() {
+			r.Size = 0
+			filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					if info, err := d.Info(); err == nil {
+						r.Size += info.Size()
+					}
+				}
+				return nil
+			})
+		}
+		list.Files = append(list.Files, r)
+		list.Total += r.Size
+	}
+	return list, nil
+}
+
+// Print the files with their dates and sizes and the total, as JSON with asJSON.
+func printRemovals(list removals, asJSON bool) error {
+	if asJSON {
+		return printJSON(list)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, r := range list.Files {
+		fmt.Fprintf(w, "%s\t%s\t%.1f MiB\n", r.Name, r.Date, float64(r.Size)/(1<<20))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Println(msg("removals-total", len(list.Files), float64(list.Total)/(1<<20)))
+	return nil
+}
+
+// Permanently remove files from the trash directory.
+func emptyTrash(args []string) error {
+	flags := flag.NewFlagSet("empty-trash", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only list the files which would be removed, with dates and sizes")
+	asJSON := flags.Bool("json", false, "print the list of -dry-run as JSON")
+	flags.Parse(args)
+
+	dir := trashDir()
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		files, err = nil, nil
+	}
+	if err != nil {
+		return fmt.Errorf("Could not read trash directory: %s", err)
+	}
+	if *dryRun {
+		names := make([]string, len(files))
+		for i, f := range files {
+			names[i] = f.Name()
+		}
+		list, err := listRemovals(dir, names)
+		if err != nil {
+			return err
+		}
+		return printRemovals(list, *asJSON)
+	}
+	for _, f := range files {
+		if err = os.RemoveAll(filepath.Join(dir, f.Name())); err != nil {
+			return fmt.Errorf("Could not remove %s from trash: %s", f.Name(), err)
+		}
+	}
+	fmt.Println(msg("trash-emptied", len(files), dir))
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Print the image url of the wallpaper at the date (the newest one by default) without downloading
+// it.
+func printURL(args []string) error {
+	flags := flag.NewFlagSet("url", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print date, url, title and description as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: bingwallpaper url [-json] [YYYYMMDD]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var links []link
+	if flags.NArg() > 0 {
+		date, err := time.Parse(localDateLayout, flags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("url: malformed date: %s", err)
+		}
+		links, err = listLinks(date, date)
+		if err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			return fmt.Errorf("url: no wallpaper at %s", flags.Arg(0))
+		}
+	} else {
+		var err error
+		links, err = listLinks(today, today.AddDate(0, 0, *futureDays))
+		if err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			return fmt.Errorf("url: no wallpaper for today yet")
+		}
+	}
+
+	wp, err := resolveWallpaper(links[len(links)-1].url)
+	if err != nil {
+		return err
+	}
+	if !*asJSON {
+		fmt.Println(wp.src)
+		return nil
+	}
+	return printJSON(struct {
+		Date        string `json:"date"`
+		URL         string `json:"url"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}{wp.date.Format(localDateLayout), wp.src, wp.title, wp.description})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Result of verifying an entry with a problem.
+type problem struct {
+	Date     string `json:"date"`
+	Filename string `json:"filename"`
+	Status   string `json:"status"`
+	Error    string `json:"error"`
+	Repaired bool   `json:"repaired,omitempty"`
+}
+
+// Summary of verify.
+type verification struct {
+	Healthy  int       `json:"healthy"`
+	Missing  int       `json:"missing"`
+	Corrupt  int       `json:"corrupt"`
+	Repaired int       `json:"repaired"`
+	Problems []problem `json:"problems"`
+	// Problem of the wallpapers file itself, see -store-checksum.
+	Store         string `json:"store,omitempty"`
+	StoreRepaired bool   `json:"store_repaired,omitempty"`
+}
+
+// Check every stored image with checkImage and report missing and corrupt ones.
+func verify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	repair := flags.Bool("repair", false, "download missing and corrupt images again")
+	rehash := flags.Bool("rehash", false, "store checksums of healthy images which have none")
+	flags.Parse(args)
+
+	st := &store{path: wpFile}
+	entries, err := st.entries()
+	if err != nil {
+		return err
+	}
+
+	result := verification{Problems: make([]problem, 0)}
+	if err = st.checkSeal(); err != nil {
+		result.Store = err.Error()
+		// Rewriting the entries which could be read seals the file again.
+		if *repair {
+			if err = st.update(func(entries []entry) []entry { return entries }); err != nil {
+				return err
+			}
+			result.StoreRepaired = true
+		}
+	}
+	var bad []entry
+	sums := make(map[time.Time]string)
+	for _, e := range entries {
+		err := checkImage(e)
+		if err == nil {
+			result.Healthy++
+			if *rehash && e.sha256 == "" {
+				sum, err := fileChecksum(filepath.Join(imgDir, e.filename))
+				if err != nil {
+					return err
+				}
+				sums[e.date] = sum
+			}
+			continue
+		}
+		p := problem{Date: e.date.Format(localDateLayout), Filename: e.filename, Error: err.Error()}
+		switch {
+		case errors.Is(err, errMissing):
+			p.Status = "missing"
+			result.Missing++
+		case errors.Is(err, errCorrupt):
+			p.Status = "corrupt"
+			result.Corrupt++
+		default:
+			return err
+		}
+		result.Problems = append(result.Problems, p)
+		bad = append(bad, e)
+	}
+	if len(sums) > 0 {
+		err = st.update(func(entries []entry) []entry {
+			for i, e := range entries {
+				if sum, ok := sums[e.date]; ok && e.sha256 == "" {
+					entries[i].sha256 = sum
+				}
+			}
+			return entries
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if *repair && len(bad) > 0 {
+		repaired, err := repairEntries(bad)
+		if err != nil {
+			return err
+		}
+		for i := range result.Problems {
+			if repaired[result.Problems[i].Date] {
+				result.Problems[i].Repaired = true
+				result.Repaired++
+			}
+		}
+	}
+
+	if *asJSON {
+		if err = printJSON(result); err != nil {
+			return err
+		}
+	} else {
+		if result.Store != "" {
+			line := result.Store
+			if result.StoreRepaired {
+				line += ", rewritten"
+			}
+			fmt.Println(line)
+		}
+		for _, p := range result.Problems {
+			line := p.Error
+			if p.Repaired {
+				line += ", repaired"
+			}
+			fmt.Println(line)
+		}
+		fmt.Printf("%d healthy, %d missing, %d corrupt, %d repaired\n", result.Healthy, result.Missing, result.Corrupt, result.Repaired)
+		if len(sums) > 0 {
+			fmt.Printf("%d checksums stored\n", len(sums))
+		}
+	}
+	if result.Store != "" && !result.StoreRepaired {
+		return fmt.Errorf("verify: %s", result.Store)
+	}
+	if left := len(result.Problems) - result.Repaired; left > 0 {
+		return fmt.Errorf("verify: %d of %d images have problems", left, len(entries))
+	}
+	return nil
+}
+
+// Download the given dates, or with -all-corrupt those verify reports as missing or corrupt, again
+// and update their entries. The wallpaper isn't set.
+func redownload(args []string) error {
+	flags := flag.NewFlagSet("redownload", flag.ExitOnError)
+	allCorrupt := flags.Bool("all-corrupt", false, "download every missing or corrupt image again")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: bingwallpaper redownload -all-corrupt | YYYYMMDD...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *allCorrupt == (flags.NArg() > 0) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	entries, err := (&store{path: wpFile}).entries()
+	if err != nil {
+		return err
+	}
+	stored := make(map[time.Time]bool, len(entries))
+	for _, e := range entries {
+		stored[e.date] = true
+	}
+	wanted := make(map[time.Time]bool, flags.NArg())
+	for _, arg := range flags.Args() {
+		date, err := time.Parse(localDateLayout, arg)
+		if err != nil {
+			return fmt.Errorf("redownload: malformed date: %s", err)
+		}
+		if !stored[date] {
+			return fmt.Errorf("redownload: no wallpaper stored at %s", arg)
+		}
+		wanted[date] = true
+	}
+	// The entries keep their order from the newest to the oldest.
+	var bad []entry
+	for _, e := range entries {
+		if *allCorrupt {
+			if err := checkImage(e); errors.Is(err, errMissing) || errors.Is(err, errCorrupt) {
+				bad = append(bad, e)
+			}
+		} else if wanted[e.date] {
+			bad = append(bad, e)
+		}
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+
+	if err := ensureImgDir(); err != nil {
+		return err
+	}
+	repaired, err := repairEntries(bad)
+	if err != nil {
+		return err
+	}
+	for _, e := range bad {
+		date := e.date.Format(localDateLayout)
+		if repaired[date] {
+			fmt.Println(date, e.filename)
+		}
+	}
+	if len(repaired) < len(bad) {
+		return fmt.Errorf("redownload: %d of %d dates failed", len(bad)-len(repaired), len(bad))
+	}
+	return nil
+}
+
+// Download the entries' dates again and log them. Returns the repaired dates. Entries keeping the
+// url of their page with photo are downloaded from it, only the others are looked up in the listing.
+func repairEntries(bad []entry) (map[string]bool, error) {
+	repaired := make(map[string]bool)
+	// Entries are sorted from the newest to the oldest.
+	var unlinked []entry
+	for _, e := range bad {
+		if e.page == "" {
+			unlinked = append(unlinked, e)
+			continue
+		}
+		ok, err := repairDate(e.date, downloadDetail, e.page)
+		if err != nil {
+			return repaired, err
+		}
+		if ok {
+			repaired[e.date.Format(localDateLayout)] = true
+		}
+	}
+	if len(unlinked) == 0 {
+		return repaired, nil
+	}
+
+	links, err := listLinks(unlinked[len(unlinked)-1].date, unlinked[0].date)
+	if err != nil {
+		return repaired, err
+	}
+	wanted := make(map[string]bool, len(unlinked))
+	for _, e := range unlinked {
+		wanted[e.date.Format(localDateLayout)] = true
+	}
+	for _, l := range links {
+		date := l.date.Format(localDateLayout)
+		if !wanted[date] {
+			continue
+		}
+		ok, err := repairDate(l.date, downloadWallpaper, l.url)
+		if err != nil {
+			return repaired, err
+		}
+		if ok {
+			repaired[date] = true
+		}
+	}
+	return repaired, nil
+}
+
+// Download the date from the url with the function and log it. Returns whether it was repaired;
+// the error is of the store, which stops the repair.
+func repairDate(date time.Time, download func(url string) (wallpaper, error), url string) (bool, error) {
+	wp, err := download(url)
+	if errors.Is(err, errStore) {
+		return false, err
+	}
+	if err == nil && !wp.date.Equal(date) {
+		err = fmt.Errorf("%s: %s is the page of %s", date.Format(localDateLayout), url, wp.date.Format(localDateLayout))
+	}
+	if err != nil {
+		log.Println(err)
+		return false, nil
+	}
+	return true, logWallpaper(wp)
+}
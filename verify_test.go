@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+// Entries keeping their page with photo are repaired from it, only the others need the listing.
+func TestRepairEntriesFromPage(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		page         string
+		wantListings int
+	}{
+		{"stored page", baseURL + "/detail/20240101.html", 0},
+		{"without page", "", 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useTempDir(t)
+			setFlag(t, &today, mustParseDate(t, "20240102"))
+			site := newFakeSite(t, "20240102", "20240101")
+			bad := []entry{{date: mustParseDate(t, "20240101"), filename: "20240101.jpg", page: test.page}}
+			repaired, err := repairEntries(bad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !repaired["20240101"] {
+				t.Errorf("repaired %v, want 20240101", repaired)
+			}
+			if n := site.requests["/list/new/desc/classic.html"]; n != test.wantListings {
+				t.Errorf("listing requested %d times, want %d", n, test.wantListings)
+			}
+			if _, stored, err := (&store{path: wpFile}).get(bad[0].date); !stored || err != nil {
+				t.Errorf("repaired date not logged: %v", err)
+			}
+		})
+	}
+}
+
+// A stored page which now shows another date doesn't repair the entry with it.
+func TestRepairEntriesPageOfAnotherDate(t *testing.T) {
+	useTempDir(t)
+	newFakeSite(t, "20240102", "20240101")
+	bad := []entry{{date: mustParseDate(t, "20240101"), filename: "20240101.jpg", page: baseURL + "/detail/20240102.html"}}
+	repaired, err := repairEntries(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repaired) != 0 {
+		t.Errorf("repaired %v, want none", repaired)
+	}
+	if entries, _ := (&store{path: wpFile}).entries(); len(entries) != 0 {
+		t.Errorf("logged %s, want nothing", entryDates(entries))
+	}
+}
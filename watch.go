@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Time for a new monitor layout to settle, events come in bursts while outputs are configured.
+const settleDelay = 2 * time.Second
+
+// Set the newest wallpaper again whenever monitors are added, removed or reconfigured, e.g. when
+// a laptop is docked, since setters only cover the monitors present when they ran. It runs until
+// it is killed, e.g. started by the autostart of the session. Events come from the compositor on
+// Sway and Hyprland; on X11 the list of monitors is polled. With -per-workspace each workspace
+// gets a wallpaper of its own, set when it is focused.
+func watchMonitors(args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := flags.Duration("interval", 5*time.Second, "how often monitors are polled on X11")
+	perWorkspace := flags.Bool("per-workspace", false, "set the newest wallpaper on workspace 1, the one before on workspace 2 and so on when a workspace is focused, on i3, Sway and Hyprland")
+	flags.Parse(args)
+
+	if *noSet {
+		return fmt.Errorf("watch: there is no setter with -no-set")
+	}
+	if isHeadless() {
+		return fmt.Errorf("watch: there is no display session")
+	}
+	changes := make(chan struct{}, 1)
+	changed := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	errs := make(chan error, 2)
+	// Number of the focused workspace with -per-workspace, 0 for the newest wallpaper.
+	workspace := 0
+	workspaces := make(chan int, 16)
+	if *perWorkspace {
+		// Other window managers keep the newest wallpaper on all workspaces.
+		if tool := workspaceTool(); tool == "" {
+			log.Print(msg("no-workspaces"))
+		} else {
+			var err error
+			if workspace, err = focusedWorkspace(tool); err != nil {
+				log.Print(err)
+			}
+			go func() {
+				errs <- watchWorkspaces(tool, func(num int) { workspaces <- num })
+			}()
+		}
+	}
+	go func() {
+		switch {
+		case os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != "":
+			errs <- watchHyprland(changed)
+		case os.Getenv("SWAYSOCK") != "":
+			errs <- watchSway(changed)
+		default:
+			errs <- pollXrandr(*interval, changed)
+		}
+	}()
+
+	for {
+		monitors := false
+		select {
+		case err := <-errs:
+			return err
+		case num := <-workspaces:
+			if num == workspace {
+				continue
+			}
+			workspace = num
+		case <-changes:
+			monitors = true
+			time.Sleep(settleDelay)
+			select {
+			case <-changes:
+			default:
+			}
+		}
+		entries, err := (&store{path: wpFile}).entries()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		wp := workspaceWallpaper(entries, workspace)
+		if monitors {
+			log.Print(msg("monitors-changed", wp.filename))
+		} else {
+			log.Print(msg("workspace-focused", workspace, wp.filename))
+		}
+		if _, err = applyWallpaper(wp); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// Report output events of Sway.
+func watchSway(changed func()) error {
+	cmd := exec.Command("swaymsg", "-t", "subscribe", "-m", `["output"]`)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("Could not start swaymsg: %s", err)
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		changed()
+	}
+	return fmt.Errorf("swaymsg subscription ended: %v", cmd.Wait())
+}
+
+// Report monitor events of Hyprland.
+func watchHyprland(changed func()) error {
+	return hyprlandEvents(func(event, _ string) {
+		if strings.HasPrefix(event, "monitor") {
+			changed()
+		}
+	})
+}
+
+// Pass the events of the event socket of Hyprland to handle, which is in $XDG_RUNTIME_DIR/hypr
+// since Hyprland 0.40 and in /tmp/hypr before.
+func hyprlandEvents(handle func(event, data string)) error {
+	signature := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+	var conn net.Conn
+	var err error
+	for _, dir := range []string{filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "hypr"), "/tmp/hypr"} {
+		if conn, err = net.Dial("unix", filepath.Join(dir, signature, ".socket2.sock")); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Could not connect to the event socket of Hyprland: %s", err)
+	}
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		event, data, _ := strings.Cut(scanner.Text(), ">>")
+		handle(event, data)
+	}
+	if err = scanner.Err(); err == nil {
+		err = io.EOF
+	}
+	return fmt.Errorf("Event socket of Hyprland closed: %s", err)
+}
+
+// Report changes of the monitors listed by xrandr, which has no events.
+func pollXrandr(interval time.Duration, changed func()) error {
+	var last []byte
+	for ; ; time.Sleep(interval) {
+		output, err := exec.Command("xrandr", "--listmonitors").Output()
+		if err != nil {
+			return fmt.Errorf("xrandr failed: %s", err)
+		}
+		if last != nil && !bytes.Equal(output, last) {
+			changed()
+		}
+		last = output
+	}
+}
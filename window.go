@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Daily span of local time, in minutes after midnight. A window ending before its start spans
+// midnight, e.g. 22:00-06:00. The zero window has no bounds.
+type timeWindow struct {
+	from, to int
+	set      bool
+}
+
+// Window of -set-window.
+var setWindowRange timeWindow
+
+// Parse a window like 00:00-06:00. An empty value is the zero window.
+func parseWindow(value string) (timeWindow, error) {
+	if value == "" {
+		return timeWindow{}, nil
+	}
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return timeWindow{}, fmt.Errorf("Invalid window %q, expected e.g. 00:00-06:00", value)
+	}
+	w := timeWindow{set: true}
+	for _, bound := range []struct {
+		value   string
+		minutes *int
+	}{{from, &w.from}, {to, &w.to}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound.value))
+		if err != nil {
+			return timeWindow{}, fmt.Errorf("Invalid window %q, expected e.g. 00:00-06:00", value)
+		}
+		*bound.minutes = t.Hour()*60 + t.Minute()
+	}
+	return w, nil
+}
+
+// Whether the local time t is within the window.
+func (w timeWindow) contains(t time.Time) bool {
+	if !w.set {
+		return true
+	}
+	m := t.Hour()*60 + t.Minute()
+	if w.from <= w.to {
+		return w.from <= m && m < w.to
+	}
+	return m >= w.from || m < w.to
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// Program reporting workspaces of the session for watch -per-workspace: hyprctl, swaymsg or
+// i3-msg, empty if the window manager has no workspaces known to the script.
+func workspaceTool() string {
+	switch {
+	case os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != "":
+		return "hyprctl"
+	case os.Getenv("SWAYSOCK") != "":
+		return "swaymsg"
+	case os.Getenv("I3SOCK") != "":
+		return "i3-msg"
+	}
+	return ""
+}
+
+// Stored wallpaper of the workspace: the newest on workspace 1, the one before on workspace 2 and
+// so on, starting over if there are more workspaces than wallpapers. Named workspaces without a
+// number get the newest.
+func workspaceWallpaper(entries []entry, num int) wallpaper {
+	if num < 1 {
+		return entries[0].wallpaper()
+	}
+	return entries[(num-1)%len(entries)].wallpaper()
+}
+
+// Number of the focused workspace, 0 if it has none.
+func focusedWorkspace(tool string) (int, error) {
+	if tool == "hyprctl" {
+		output, err := exec.Command("hyprctl", "activeworkspace", "-j").Output()
+		if err != nil {
+			return 0, fmt.Errorf("hyprctl failed: %s", err)
+		}
+		var workspace struct {
+			ID int `json:"id"`
+		}
+		if err = json.Unmarshal(output, &workspace); err != nil {
+			return 0, fmt.Errorf("Could not parse the output of hyprctl: %s", err)
+		}
+		return max(workspace.ID, 0), nil
+	}
+	output, err := exec.Command(tool, "-t", "get_workspaces").Output()
+	if err != nil {
+		return 0, fmt.Errorf("%s failed: %s", tool, err)
+	}
+	var workspaces []struct {
+		Num     int  `json:"num"`
+		Focused bool `json:"focused"`
+	}
+	if err = json.Unmarshal(output, &workspaces); err != nil {
+		return 0, fmt.Errorf("Could not parse the output of %s: %s", tool, err)
+	}
+	for _, w := range workspaces {
+		if w.Focused {
+			return max(w.Num, 0), nil
+		}
+	}
+	return 0, nil
+}
+
+// Report the number of each workspace which gets the focus.
+func watchWorkspaces(tool string, focused func(int)) error {
+	if tool == "hyprctl" {
+		return hyprlandEvents(func(event, data string) {
+			if event == "workspace" {
+				num, _ := strconv.Atoi(data)
+				focused(max(num, 0))
+			}
+		})
+	}
+	// i3 and Sway send the same events, swaymsg only needs -r to print them unindented.
+	args := []string{"-t", "subscribe", "-m", `["workspace"]`}
+	if tool == "swaymsg" {
+		args = append(args, "-r")
+	}
+	cmd := exec.Command(tool, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("Could not start %s: %s", tool, err)
+	}
+	decoder := json.NewDecoder(stdout)
+	for {
+		var event struct {
+			Change  string `json:"change"`
+			Current struct {
+				Num int `json:"num"`
+			} `json:"current"`
+		}
+		if err = decoder.Decode(&event); err != nil {
+			break
+		}
+		if event.Change == "focus" {
+			focused(max(event.Current.Num, 0))
+		}
+	}
+	return fmt.Errorf("%s subscription ended: %v", tool, cmd.Wait())
+}